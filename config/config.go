@@ -4,7 +4,9 @@ import (
   "time"
 
   errs "flussonic_tz/internal/errors"
+  "flussonic_tz/models"
 
+  "github.com/go-viper/mapstructure/v2"
   "github.com/pkg/errors"
   "github.com/rs/zerolog/log"
   "github.com/spf13/viper"
@@ -12,12 +14,49 @@ import (
 
 // worker pool
 const (
-  Workers          = 5
-  JobLimit         = 100
-  JobInterval      = 1 * time.Minute
-  MaxRetries       = 3
-  Timeout          = 3 * time.Second
-  ErrorProbability = 0.1
+  Workers           = 5
+  JobLimit          = 100
+  JobInterval       = 1 * time.Minute
+  MaxRetries        = 3
+  Timeout           = 3 * time.Second
+  ErrorProbability  = 0.1
+  CancelGracePeriod = 1 * time.Second
+  RateAlgorithm     = "sliding_window"
+  RateBurst         = 10
+  RetryInitialDelay = 1 * time.Second
+  RetryMultiplier   = 2
+  RetryMaxDelay     = 1 * time.Minute
+  RetryJitter       = 0.2
+  PromoteInterval   = 1 * time.Second
+  LeaseDuration     = 30 * time.Second
+  ReaperInterval    = 5 * time.Second
+  SweepInterval     = 1 * time.Second
+  MaxRedeliveries   = 5
+  DrainTimeout      = 10 * time.Second
+  IdleBackoff       = 2 * time.Second
+  MaxWorkers        = 100
+  ProgressInterval  = 1 * time.Second
+  RegistryInterval  = 5 * time.Second
+  RegistryTTL       = 15 * time.Second
+)
+
+// autoscaler
+const (
+  AutoscaleMinWorkers        = 1
+  AutoscaleMaxWorkers        = 20
+  AutoscaleInterval          = 5 * time.Second
+  AutoscaleTargetDrain       = 30 * time.Second
+  AutoscaleScaleUpCooldown   = 15 * time.Second
+  AutoscaleScaleDownCooldown = 1 * time.Minute
+  AutoscaleHysteresisWindow  = 30 * time.Second
+)
+
+// cron
+const (
+  CronPollInterval = 1 * time.Second
+  CronMaxCatchUp   = 100
+  CronMissedSkip   = "skip"
+  CronMissedCatch  = "catch_up"
 )
 
 // redis
@@ -34,6 +73,8 @@ const (
   RedisIdleTimeout     = 10 * time.Minute
   RedisMinRetryBackoff = 100 * time.Millisecond
   RedisMaxRetryBackoff = 1 * time.Second
+  RedisResultTTL       = 24 * time.Hour
+  RedisMaxResultSize   = 1 << 20
 )
 
 // http server
@@ -44,21 +85,70 @@ const (
   WriteTimeout    = time.Second * 5
   ShutdownTimeout = time.Second * 30
   IdleTimeout     = time.Second * 60
+  MaxPayloadSize  = 1 << 20
+  MaxWorkflowJobs = 100
+  MaxBatchJobs    = 1000
+  IdempotencyTTL  = time.Hour * 24
 )
 
 type Config struct {
   WorkerPool WorkerPool `yaml:"workerpool" mapstructure:"workerpool"`
   Redis      Redis      `yaml:"redis" mapstructure:"redis"`
   Server     Server     `yaml:"server" mapstructure:"server"`
+  Cron       Cron       `yaml:"cron" mapstructure:"cron"`
 }
 
 type WorkerPool struct {
-  Workers          int           `yaml:"workers" mapstructure:"workers"`
-  JobLimit         int           `yaml:"job_limit" mapstructure:"job_limit"`
-  JobInterval      time.Duration `yaml:"job_interval" mapstructure:"job_interval"`
-  MaxRetries       int           `yaml:"max_retries" mapstructure:"max_retries"`
-  Timeout          time.Duration `yaml:"timeout" mapstructure:"timeout"`
-  ErrorProbability float64       `yaml:"error_probability" mapstructure:"error_probability"`
+  Workers           int           `yaml:"workers" mapstructure:"workers"`
+  MaxWorkers        int           `yaml:"max_workers" mapstructure:"max_workers"`
+  JobLimit          int           `yaml:"job_limit" mapstructure:"job_limit"`
+  JobInterval       time.Duration `yaml:"job_interval" mapstructure:"job_interval"`
+  MaxRetries        int           `yaml:"max_retries" mapstructure:"max_retries"`
+  Timeout           time.Duration `yaml:"timeout" mapstructure:"timeout"`
+  ErrorProbability  float64       `yaml:"error_probability" mapstructure:"error_probability"`
+  CancelGracePeriod time.Duration `yaml:"cancel_grace_period" mapstructure:"cancel_grace_period"`
+  RateAlgorithm     string        `yaml:"rate_algorithm" mapstructure:"rate_algorithm"`
+  RateBurst         int           `yaml:"rate_burst" mapstructure:"rate_burst"`
+  PromoteInterval   time.Duration `yaml:"promote_interval" mapstructure:"promote_interval"`
+  LeaseDuration     time.Duration `yaml:"lease_duration" mapstructure:"lease_duration"`
+  ReaperInterval    time.Duration `yaml:"reaper_interval" mapstructure:"reaper_interval"`
+  SweepInterval     time.Duration `yaml:"sweep_interval" mapstructure:"sweep_interval"`
+  MaxRedeliveries   int           `yaml:"max_redeliveries" mapstructure:"max_redeliveries"`
+  DrainTimeout      time.Duration `yaml:"drain_timeout" mapstructure:"drain_timeout"`
+  IdleBackoff       time.Duration `yaml:"idle_backoff" mapstructure:"idle_backoff"`
+  ProgressInterval  time.Duration `yaml:"progress_interval" mapstructure:"progress_interval"`
+  RegistryInterval  time.Duration `yaml:"registry_interval" mapstructure:"registry_interval"`
+  RegistryTTL       time.Duration `yaml:"registry_ttl" mapstructure:"registry_ttl"`
+
+  Retry         models.RetryPolicy            `yaml:"retry" mapstructure:"retry"`
+  RetryPolicies map[string]models.RetryPolicy `yaml:"retry_policies" mapstructure:"retry_policies"`
+  Autoscale     Autoscale                     `yaml:"autoscale" mapstructure:"autoscale"`
+}
+
+type Autoscale struct {
+  Enabled           bool          `yaml:"enabled" mapstructure:"enabled"`
+  MinWorkers        int           `yaml:"min_workers" mapstructure:"min_workers"`
+  MaxWorkers        int           `yaml:"max_workers" mapstructure:"max_workers"`
+  Interval          time.Duration `yaml:"interval" mapstructure:"interval"`
+  TargetDrain       time.Duration `yaml:"target_drain" mapstructure:"target_drain"`
+  ScaleUpCooldown   time.Duration `yaml:"scale_up_cooldown" mapstructure:"scale_up_cooldown"`
+  ScaleDownCooldown time.Duration `yaml:"scale_down_cooldown" mapstructure:"scale_down_cooldown"`
+  HysteresisWindow  time.Duration `yaml:"hysteresis_window" mapstructure:"hysteresis_window"`
+}
+
+type Cron struct {
+  PollInterval time.Duration `yaml:"poll_interval" mapstructure:"poll_interval"`
+  MaxCatchUp   int           `yaml:"max_catch_up" mapstructure:"max_catch_up"`
+  Jobs         []CronJob     `yaml:"jobs" mapstructure:"jobs"`
+}
+
+type CronJob struct {
+  Name     string  `yaml:"name" mapstructure:"name"`
+  Schedule string  `yaml:"schedule" mapstructure:"schedule"`
+  Timezone string  `yaml:"timezone" mapstructure:"timezone"`
+  Payload  string  `yaml:"payload" mapstructure:"payload"`
+  Score    float64 `yaml:"score" mapstructure:"score"`
+  Missed   string  `yaml:"missed" mapstructure:"missed"`
 }
 
 type Redis struct {
@@ -74,6 +164,8 @@ type Redis struct {
   MinRetryBackoff time.Duration `yaml:"min_retry_backoff" mapstructure:"min_retry_backoff"`
   MaxRetryBackoff time.Duration `yaml:"max_retry_backoff" mapstructure:"max_retry_backoff"`
   QueueName       string        `yaml:"queue_name" mapstructure:"queue_name"`
+  ResultTTL       time.Duration `yaml:"result_ttl" mapstructure:"result_ttl"`
+  MaxResultSize   int64         `yaml:"max_result_size" mapstructure:"max_result_size"`
 }
 
 type Server struct {
@@ -83,6 +175,10 @@ type Server struct {
   WriteTimeout    time.Duration `yaml:"write_timeout" mapstructure:"write_timeout"`
   ShutdownTimeout time.Duration `yaml:"shutdown_timeout" mapstructure:"shutdown_timeout"`
   IdleTimeout     time.Duration `yaml:"idle_timeout" mapstructure:"idle_timeout"`
+  MaxPayloadSize  int64         `yaml:"max_payload_size" mapstructure:"max_payload_size"`
+  MaxWorkflowJobs int           `yaml:"max_workflow_jobs" mapstructure:"max_workflow_jobs"`
+  MaxBatchJobs    int           `yaml:"max_batch_jobs" mapstructure:"max_batch_jobs"`
+  IdempotencyTTL  time.Duration `yaml:"idempotency_ttl" mapstructure:"idempotency_ttl"`
 }
 
 func New() (*Config, error) {
@@ -95,7 +191,12 @@ func New() (*Config, error) {
   }
 
   var config Config
-  if err := viper.Unmarshal(&config); err != nil {
+  // models.Duration в политиках ретраев читается через UnmarshalText
+  decodeHook := mapstructure.ComposeDecodeHookFunc(
+    mapstructure.TextUnmarshallerHookFunc(),
+    mapstructure.StringToTimeDurationHookFunc(),
+  )
+  if err := viper.Unmarshal(&config, viper.DecodeHook(decodeHook)); err != nil {
     wrapped := errors.Wrap(err, errs.ErrUnmarshalConfig)
     log.Error().Err(wrapped).Msg(wrapped.Error())
     return nil, wrapped
@@ -107,11 +208,36 @@ func New() (*Config, error) {
 
 func setupWorkerPool() {
   viper.SetDefault("workerpool.workers", Workers)
+  viper.SetDefault("workerpool.max_workers", MaxWorkers)
   viper.SetDefault("workerpool.job_limit", JobLimit)
   viper.SetDefault("workerpool.job_interval", JobInterval)
   viper.SetDefault("workerpool.max_retries", MaxRetries)
   viper.SetDefault("workerpool.timeout", Timeout)
   viper.SetDefault("workerpool.error_probability", ErrorProbability)
+  viper.SetDefault("workerpool.cancel_grace_period", CancelGracePeriod)
+  viper.SetDefault("workerpool.rate_algorithm", RateAlgorithm)
+  viper.SetDefault("workerpool.rate_burst", RateBurst)
+  viper.SetDefault("workerpool.promote_interval", PromoteInterval)
+  viper.SetDefault("workerpool.lease_duration", LeaseDuration)
+  viper.SetDefault("workerpool.reaper_interval", ReaperInterval)
+  viper.SetDefault("workerpool.sweep_interval", SweepInterval)
+  viper.SetDefault("workerpool.max_redeliveries", MaxRedeliveries)
+  viper.SetDefault("workerpool.drain_timeout", DrainTimeout)
+  viper.SetDefault("workerpool.idle_backoff", IdleBackoff)
+  viper.SetDefault("workerpool.progress_interval", ProgressInterval)
+  viper.SetDefault("workerpool.registry_interval", RegistryInterval)
+  viper.SetDefault("workerpool.registry_ttl", RegistryTTL)
+  viper.SetDefault("workerpool.retry.initial_delay", RetryInitialDelay)
+  viper.SetDefault("workerpool.retry.multiplier", RetryMultiplier)
+  viper.SetDefault("workerpool.retry.max_delay", RetryMaxDelay)
+  viper.SetDefault("workerpool.retry.jitter", RetryJitter)
+  viper.SetDefault("workerpool.autoscale.min_workers", AutoscaleMinWorkers)
+  viper.SetDefault("workerpool.autoscale.max_workers", AutoscaleMaxWorkers)
+  viper.SetDefault("workerpool.autoscale.interval", AutoscaleInterval)
+  viper.SetDefault("workerpool.autoscale.target_drain", AutoscaleTargetDrain)
+  viper.SetDefault("workerpool.autoscale.scale_up_cooldown", AutoscaleScaleUpCooldown)
+  viper.SetDefault("workerpool.autoscale.scale_down_cooldown", AutoscaleScaleDownCooldown)
+  viper.SetDefault("workerpool.autoscale.hysteresis_window", AutoscaleHysteresisWindow)
 }
 
 func setupRedis() {
@@ -127,6 +253,13 @@ func setupRedis() {
   viper.SetDefault("redis.min_retry_backoff", RedisMinRetryBackoff)
   viper.SetDefault("redis.max_retry_backoff", RedisMaxRetryBackoff)
   viper.SetDefault("redis.queue_name", RedisQueueName)
+  viper.SetDefault("redis.result_ttl", RedisResultTTL)
+  viper.SetDefault("redis.max_result_size", RedisMaxResultSize)
+}
+
+func setupCron() {
+  viper.SetDefault("cron.poll_interval", CronPollInterval)
+  viper.SetDefault("cron.max_catch_up", CronMaxCatchUp)
 }
 
 func setupServer() {
@@ -136,6 +269,10 @@ func setupServer() {
   viper.SetDefault("server.write_timeout", WriteTimeout)
   viper.SetDefault("server.shutdown_timeout", ShutdownTimeout)
   viper.SetDefault("server.idle_timeout", IdleTimeout)
+  viper.SetDefault("server.max_payload_size", MaxPayloadSize)
+  viper.SetDefault("server.max_workflow_jobs", MaxWorkflowJobs)
+  viper.SetDefault("server.max_batch_jobs", MaxBatchJobs)
+  viper.SetDefault("server.idempotency_ttl", IdempotencyTTL)
 }
 
 func setupViper() error {
@@ -148,6 +285,7 @@ func setupViper() error {
   setupWorkerPool()
   setupRedis()
   setupServer()
+  setupCron()
 
   if err := viper.ReadInConfig(); err != nil {
     wrapped := errors.Wrap(err, errs.ErrReadConfig)
@@ -7,6 +7,7 @@ import (
 type ContextWorkerPoolKey struct{}
 type ContextRedisKey struct{}
 type ContextServerKey struct{}
+type ContextCronKey struct{}
 
 func WrapWorkerPoolContext(ctx context.Context, data interface{}) context.Context {
   return context.WithValue(ctx, ContextWorkerPoolKey{}, data)
@@ -43,3 +44,15 @@ func FromServerContext(ctx context.Context) *Server {
   }
   return srv
 }
+
+func WrapCronContext(ctx context.Context, data interface{}) context.Context {
+  return context.WithValue(ctx, ContextCronKey{}, data)
+}
+
+func FromCronContext(ctx context.Context) *Cron {
+  cron, ok := ctx.Value(ContextCronKey{}).(*Cron)
+  if !ok {
+    return nil
+  }
+  return cron
+}
@@ -16,12 +16,17 @@ import (
   "flussonic_tz/config"
   delivery "flussonic_tz/internal/delivery/http"
   "flussonic_tz/internal/repository/redis"
+  "flussonic_tz/internal/scheduler"
   "flussonic_tz/internal/service"
   "flussonic_tz/workerpool"
 
   "github.com/go-redis/redis/v8"
 )
 
+const (
+  ExampleJobName = "example_job"
+)
+
 type App struct {
   cfg *config.Config
   srv *server.Server
@@ -53,18 +58,36 @@ func (a *App) Run() {
     MinRetryBackoff: a.cfg.Redis.MinRetryBackoff,
     MaxRetryBackoff: a.cfg.Redis.MaxRetryBackoff,
   })
-  repo := repository.NewRedisRepository(redisClient, a.cfg.Redis.QueueName)
+  repo := repository.NewRedisRepository(config.WrapRedisContext(context.Background(), &a.cfg.Redis), redisClient)
   jobSvc := service.NewJobService(repo)
-  delJob := delivery.NewJobHandler(jobSvc)
+  delJob := delivery.NewJobHandler(config.WrapServerContext(context.Background(), &a.cfg.Server), jobSvc)
 
-  workerPool := workerpool.NewWorkerPool(config.WrapWorkerPoolContext(context.Background(), &a.cfg.WorkerPool), repo)
+  workerPool, err := workerpool.NewWorkerPool(config.WrapWorkerPoolContext(context.Background(), &a.cfg.WorkerPool), repo)
+  if err != nil {
+    log.Fatal().Err(err).Msg(err.Error())
+  }
+  workerPool.Handle(ExampleJobName, workerPool.PerformJob)
   delWp := delivery.NewWorkerPoolHandler(workerPool)
+  delDlq := delivery.NewDeadLetterHandler(jobSvc)
+  delWorkflow := delivery.NewWorkflowHandler(config.WrapServerContext(context.Background(), &a.cfg.Server), jobSvc)
+  delBatch := delivery.NewBatchHandler(config.WrapServerContext(context.Background(), &a.cfg.Server), jobSvc)
+
+  cronScheduler, err := scheduler.New(config.WrapCronContext(context.Background(), &a.cfg.Cron), jobSvc, repo)
+  if err != nil {
+    log.Fatal().Err(err).Msg(err.Error())
+  }
+  delCron := delivery.NewCronHandler(cronScheduler)
+  cronScheduler.Start(context.Background())
   go workerPool.Start(context.Background())
 
   mx := router.New()
   mx.SetupMiddlewares()
   mx.SetupJob(delJob)
   mx.SetupWorkerPool(delWp)
+  mx.SetupDeadLetter(delDlq)
+  mx.SetupWorkflow(delWorkflow)
+  mx.SetupBatch(delBatch)
+  mx.SetupCron(delCron)
   a.mx = mx
 
   srv := server.New(config.WrapServerContext(context.Background(), &a.cfg.Server), a.mx.Mux())
@@ -85,8 +108,15 @@ func (a *App) Run() {
     log.Fatal().Err(wrapped).Msg(wrapped.Error())
   }
 
-  workerPool.Stop()
-  err := redisClient.Close()
+  cronScheduler.Stop()
+  summary := workerPool.Stop()
+  log.Info().
+    Int("in_flight", summary.InFlight).
+    Int("drained", summary.Drained).
+    Int("requeued", summary.Requeued).
+    Int("interrupt_failed", summary.InterruptFailed).
+    Msg("worker pool stopped")
+  err = redisClient.Close()
   if err != nil {
     wrapped := errors.Wrap(err, errs.ErrCloseRedis)
     log.Error().Err(wrapped).Msg(wrapped.Error())
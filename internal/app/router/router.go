@@ -33,9 +33,39 @@ func (r *Router) SetupMiddlewares() {
 func (r *Router) SetupJob(handler *delivery.JobHandler) {
   r.mx.Post("/jobs", handler.CreateJob)
   r.mx.Get("/jobs/{job_id}", handler.GetJobStatus)
+  r.mx.Delete("/jobs/{job_id}", handler.CancelJob)
+  r.mx.Get("/jobs/{job_id}/result", handler.GetJobResult)
 }
 
 func (r *Router) SetupWorkerPool(handler *delivery.WorkerPoolHandler) {
   r.mx.Post("/pause", handler.Pause)
   r.mx.Post("/unpause", handler.Unpause)
+  r.mx.Get("/workerpool/stuck", handler.Stuck)
+  r.mx.Get("/workerpool/stats", handler.Stats)
+  r.mx.Put("/workerpool/workers", handler.Resize)
+  r.mx.Get("/workerpool/autoscaler", handler.Autoscaler)
+  r.mx.Get("/workers", handler.Instances)
+}
+
+func (r *Router) SetupDeadLetter(handler *delivery.DeadLetterHandler) {
+  r.mx.Get("/dlq", handler.List)
+  r.mx.Delete("/dlq", handler.Purge)
+  r.mx.Post("/dlq/replay", handler.Replay)
+  r.mx.Get("/dlq/{job_id}", handler.Get)
+  r.mx.Delete("/dlq/{job_id}", handler.PurgeOne)
+  r.mx.Post("/dlq/{job_id}/replay", handler.ReplayOne)
+}
+
+func (r *Router) SetupWorkflow(handler *delivery.WorkflowHandler) {
+  r.mx.Post("/workflows", handler.Create)
+  r.mx.Get("/workflows/{workflow_id}", handler.Get)
+}
+
+func (r *Router) SetupBatch(handler *delivery.BatchHandler) {
+  r.mx.Post("/batches", handler.Create)
+  r.mx.Get("/batches/{batch_id}", handler.Get)
+}
+
+func (r *Router) SetupCron(handler *delivery.CronHandler) {
+  r.mx.Get("/cron", handler.List)
 }
@@ -1,10 +1,48 @@
 package datastructures
 
+import "flussonic_tz/models"
+
 type CreateJobResponse struct {
   Status string `json:"status"`
   ID     string `json:"id"`
 }
 
+type CreateWorkflowResponse struct {
+  Status string            `json:"status"`
+  ID     string            `json:"id"`
+  Jobs   map[string]string `json:"jobs"`
+}
+
+type CreateBatchResponse struct {
+  Status     string   `json:"status"`
+  ID         string   `json:"id"`
+  JobIDs     []string `json:"job_ids"`
+  CallbackID string   `json:"callback_id,omitempty"`
+}
+
+type CancelJobResponse struct {
+  Status         string `json:"status"`
+  ID             string `json:"id"`
+  PreviousStatus string `json:"previous_status"`
+}
+
 type GetStatusResponse struct {
   Status string `json:"status"`
 }
+
+type ResizeRequest struct {
+  Workers int `json:"workers"`
+}
+
+type DeadJobsRequest struct {
+  IDs []string `json:"ids"`
+}
+
+type ReplayResponse struct {
+  Replayed int                      `json:"replayed"`
+  Rejected []models.ReplayRejection `json:"rejected,omitempty"`
+}
+
+type PurgeResponse struct {
+  Purged int `json:"purged"`
+}
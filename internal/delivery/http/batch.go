@@ -0,0 +1,119 @@
+package http
+
+import (
+  "context"
+  "encoding/json"
+  "net/http"
+
+  "flussonic_tz/config"
+  "flussonic_tz/internal/datastructures"
+  errs "flussonic_tz/internal/errors"
+  "flussonic_tz/internal/service"
+  "flussonic_tz/models"
+
+  "github.com/go-chi/chi"
+  "github.com/pkg/errors"
+  "github.com/rs/zerolog/log"
+)
+
+type BatchService interface {
+  CreateBatch(ctx context.Context, req *models.BatchRequest) (*models.Batch, []string, error)
+  GetBatch(ctx context.Context, batchID string) (*models.Batch, error)
+}
+
+type BatchHandler struct {
+  batchSvc BatchService
+  cfg      *config.Server
+}
+
+func NewBatchHandler(ctx context.Context, batchSvc BatchService) *BatchHandler {
+  return &BatchHandler{
+    batchSvc: batchSvc,
+    cfg:      config.FromServerContext(ctx),
+  }
+}
+
+func (h *BatchHandler) Create(w http.ResponseWriter, r *http.Request) {
+  defer func() {
+    err := r.Body.Close()
+    if err != nil {
+      wrapped := errors.Wrap(err, errs.ErrCloseBody)
+      log.Error().Err(wrapped).Msg(wrapped.Error())
+    }
+  }()
+
+  // max_payload_size ограничивает сумму payload всех джоб пачки
+  r.Body = http.MaxBytesReader(w, r.Body, h.cfg.MaxPayloadSize+MaxRequestOverhead*int64(h.cfg.MaxBatchJobs))
+
+  var req models.BatchRequest
+  if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+    wrapped := errors.Wrap(err, errs.ErrDecodeBody)
+    log.Error().Err(wrapped).Msg(wrapped.Error())
+
+    var maxBytesErr *http.MaxBytesError
+    if errors.As(err, &maxBytesErr) {
+      http.Error(w, errs.ErrPayloadTooLarge, http.StatusRequestEntityTooLarge)
+      return
+    }
+
+    http.Error(w, wrapped.Error(), http.StatusBadRequest)
+    return
+  }
+
+  if len(req.Jobs) > h.cfg.MaxBatchJobs {
+    http.Error(w, errs.ErrTooManyJobs, http.StatusBadRequest)
+    return
+  }
+  var payloadSize int64
+  for _, job := range req.Jobs {
+    payloadSize += int64(len(job.Payload))
+  }
+  if req.Callback != nil {
+    payloadSize += int64(len(req.Callback.Payload))
+  }
+  if payloadSize > h.cfg.MaxPayloadSize {
+    log.Error().Int("jobs", len(req.Jobs)).Int64("size", payloadSize).Msg(errs.ErrPayloadTooLarge)
+    http.Error(w, errs.ErrPayloadTooLarge, http.StatusRequestEntityTooLarge)
+    return
+  }
+
+  batch, jobIDs, err := h.batchSvc.CreateBatch(r.Context(), &req)
+  if errors.Is(err, service.ErrInvalidBatch) || errors.Is(err, service.ErrInvalidSchedule) ||
+    errors.Is(err, service.ErrInvalidDependency) || errors.Is(err, service.ErrDependencyNotFound) {
+    http.Error(w, err.Error(), http.StatusBadRequest)
+    return
+  }
+  if err != nil {
+    log.Error().Err(err).Msg(err.Error())
+    http.Error(w, err.Error(), http.StatusInternalServerError)
+    return
+  }
+
+  w.Header().Set("Content-Type", "application/json")
+  w.WriteHeader(http.StatusAccepted)
+  err = json.NewEncoder(w).Encode(datastructures.CreateBatchResponse{
+    Status:     "created",
+    ID:         batch.ID,
+    JobIDs:     jobIDs,
+    CallbackID: batch.CallbackID,
+  })
+  if err != nil {
+    wrapped := errors.Wrap(err, errs.ErrEncodeResp)
+    log.Error().Err(wrapped).Msg(wrapped.Error())
+  }
+}
+
+func (h *BatchHandler) Get(w http.ResponseWriter, r *http.Request) {
+  batch, err := h.batchSvc.GetBatch(r.Context(), chi.URLParam(r, "batch_id"))
+  if errors.Is(err, service.ErrBatchNotFound) {
+    http.Error(w, err.Error(), http.StatusNotFound)
+    return
+  }
+  if err != nil {
+    log.Error().Err(err).Msg(err.Error())
+    http.Error(w, err.Error(), http.StatusInternalServerError)
+    return
+  }
+
+  writeJSON(w, batch)
+}
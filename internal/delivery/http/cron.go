@@ -0,0 +1,35 @@
+package http
+
+import (
+  "context"
+  "net/http"
+
+  "flussonic_tz/models"
+
+  "github.com/rs/zerolog/log"
+)
+
+type CronService interface {
+  Entries(ctx context.Context) ([]models.CronEntry, error)
+}
+
+type CronHandler struct {
+  cronSvc CronService
+}
+
+func NewCronHandler(cronSvc CronService) *CronHandler {
+  return &CronHandler{
+    cronSvc: cronSvc,
+  }
+}
+
+func (h *CronHandler) List(w http.ResponseWriter, r *http.Request) {
+  entries, err := h.cronSvc.Entries(r.Context())
+  if err != nil {
+    log.Error().Err(err).Msg(err.Error())
+    http.Error(w, err.Error(), http.StatusInternalServerError)
+    return
+  }
+
+  writeJSON(w, entries)
+}
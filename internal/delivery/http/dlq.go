@@ -0,0 +1,189 @@
+package http
+
+import (
+  "context"
+  "encoding/json"
+  "net/http"
+  "strconv"
+
+  "flussonic_tz/internal/datastructures"
+  errs "flussonic_tz/internal/errors"
+  "flussonic_tz/internal/service"
+  "flussonic_tz/models"
+
+  "github.com/go-chi/chi"
+  "github.com/pkg/errors"
+  "github.com/rs/zerolog/log"
+)
+
+const (
+  DefaultPageSize = 50
+  MaxPageSize     = 500
+)
+
+type DeadLetterService interface {
+  ListDeadJobs(ctx context.Context, offset, limit int) (*models.DeadJobsPage, error)
+  GetDeadJob(ctx context.Context, jobID string) (*models.DeadJob, error)
+  ReplayDeadJobs(ctx context.Context, jobIDs []string) (int, []models.ReplayRejection, error)
+  PurgeDeadJobs(ctx context.Context, jobIDs []string) (int, error)
+}
+
+type DeadLetterHandler struct {
+  dlqSvc DeadLetterService
+}
+
+func NewDeadLetterHandler(dlqSvc DeadLetterService) *DeadLetterHandler {
+  return &DeadLetterHandler{
+    dlqSvc: dlqSvc,
+  }
+}
+
+func (h *DeadLetterHandler) List(w http.ResponseWriter, r *http.Request) {
+  offset, err := queryInt(r, "offset", 0)
+  if err != nil || offset < 0 {
+    http.Error(w, errs.ErrInvalidPagination, http.StatusBadRequest)
+    return
+  }
+  limit, err := queryInt(r, "limit", DefaultPageSize)
+  if err != nil || limit < 1 || limit > MaxPageSize {
+    http.Error(w, errs.ErrInvalidPagination, http.StatusBadRequest)
+    return
+  }
+
+  page, err := h.dlqSvc.ListDeadJobs(r.Context(), offset, limit)
+  if err != nil {
+    log.Error().Err(err).Msg(err.Error())
+    http.Error(w, err.Error(), http.StatusInternalServerError)
+    return
+  }
+
+  writeJSON(w, page)
+}
+
+func (h *DeadLetterHandler) Get(w http.ResponseWriter, r *http.Request) {
+  job, err := h.dlqSvc.GetDeadJob(r.Context(), chi.URLParam(r, "job_id"))
+  if errors.Is(err, service.ErrNotInDeadLetter) {
+    http.Error(w, err.Error(), http.StatusNotFound)
+    return
+  }
+  if err != nil {
+    log.Error().Err(err).Msg(err.Error())
+    http.Error(w, err.Error(), http.StatusInternalServerError)
+    return
+  }
+
+  writeJSON(w, job)
+}
+
+func (h *DeadLetterHandler) ReplayOne(w http.ResponseWriter, r *http.Request) {
+  replayed, rejected, err := h.dlqSvc.ReplayDeadJobs(r.Context(), []string{chi.URLParam(r, "job_id")})
+  if err != nil {
+    log.Error().Err(err).Msg(err.Error())
+    http.Error(w, err.Error(), http.StatusInternalServerError)
+    return
+  }
+  if len(rejected) > 0 {
+    http.Error(w, errs.ErrReplayRejected+": "+rejected[0].Reason, http.StatusConflict)
+    return
+  }
+  if replayed == 0 {
+    http.Error(w, errs.ErrNotInDeadLetter, http.StatusNotFound)
+    return
+  }
+
+  writeJSON(w, datastructures.ReplayResponse{Replayed: replayed})
+}
+
+func (h *DeadLetterHandler) Replay(w http.ResponseWriter, r *http.Request) {
+  req, ok := decodeDeadJobsRequest(w, r)
+  if !ok {
+    return
+  }
+  if len(req.IDs) == 0 {
+    http.Error(w, errs.ErrNoJobIDs, http.StatusBadRequest)
+    return
+  }
+
+  replayed, rejected, err := h.dlqSvc.ReplayDeadJobs(r.Context(), req.IDs)
+  if err != nil {
+    log.Error().Err(err).Msg(err.Error())
+    http.Error(w, err.Error(), http.StatusInternalServerError)
+    return
+  }
+
+  writeJSON(w, datastructures.ReplayResponse{Replayed: replayed, Rejected: rejected})
+}
+
+func (h *DeadLetterHandler) PurgeOne(w http.ResponseWriter, r *http.Request) {
+  purged, err := h.dlqSvc.PurgeDeadJobs(r.Context(), []string{chi.URLParam(r, "job_id")})
+  if err != nil {
+    log.Error().Err(err).Msg(err.Error())
+    http.Error(w, err.Error(), http.StatusInternalServerError)
+    return
+  }
+  if purged == 0 {
+    http.Error(w, errs.ErrNotInDeadLetter, http.StatusNotFound)
+    return
+  }
+
+  writeJSON(w, datastructures.PurgeResponse{Purged: purged})
+}
+
+// Purge удаляет перечисленные джобы, а без тела запроса - всю dead letter queue
+func (h *DeadLetterHandler) Purge(w http.ResponseWriter, r *http.Request) {
+  var req datastructures.DeadJobsRequest
+  if r.ContentLength != 0 {
+    var ok bool
+    if req, ok = decodeDeadJobsRequest(w, r); !ok {
+      return
+    }
+  }
+
+  purged, err := h.dlqSvc.PurgeDeadJobs(r.Context(), req.IDs)
+  if err != nil {
+    log.Error().Err(err).Msg(err.Error())
+    http.Error(w, err.Error(), http.StatusInternalServerError)
+    return
+  }
+
+  writeJSON(w, datastructures.PurgeResponse{Purged: purged})
+}
+
+func decodeDeadJobsRequest(w http.ResponseWriter, r *http.Request) (datastructures.DeadJobsRequest, bool) {
+  defer func() {
+    err := r.Body.Close()
+    if err != nil {
+      wrapped := errors.Wrap(err, errs.ErrCloseBody)
+      log.Error().Err(wrapped).Msg(wrapped.Error())
+    }
+  }()
+
+  var req datastructures.DeadJobsRequest
+  if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+    wrapped := errors.Wrap(err, errs.ErrDecodeBody)
+    log.Error().Err(wrapped).Msg(wrapped.Error())
+    http.Error(w, wrapped.Error(), http.StatusBadRequest)
+    return req, false
+  }
+
+  return req, true
+}
+
+func queryInt(r *http.Request, name string, fallback int) (int, error) {
+  value := r.URL.Query().Get(name)
+  if value == "" {
+    return fallback, nil
+  }
+
+  return strconv.Atoi(value)
+}
+
+func writeJSON(w http.ResponseWriter, data interface{}) {
+  w.Header().Set("Content-Type", "application/json")
+  err := json.NewEncoder(w).Encode(data)
+  if err != nil {
+    wrapped := errors.Wrap(err, errs.ErrEncodeResp)
+    log.Error().Err(wrapped).Msg(wrapped.Error())
+    http.Error(w, wrapped.Error(), http.StatusInternalServerError)
+  }
+}
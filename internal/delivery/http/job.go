@@ -4,9 +4,12 @@ import (
   "context"
   "encoding/json"
   "net/http"
+  "time"
 
+  "flussonic_tz/config"
   "flussonic_tz/internal/datastructures"
   errs "flussonic_tz/internal/errors"
+  "flussonic_tz/internal/service"
   "flussonic_tz/models"
 
   "github.com/go-chi/chi"
@@ -14,19 +17,34 @@ import (
   "github.com/rs/zerolog/log"
 )
 
+const (
+  MaxRequestOverhead  = 64 << 10
+  DefaultCancelReason = "cancelled by client"
+
+  IdempotencyKeyHeader    = "Idempotency-Key"
+  IdempotentReplayHeader  = "Idempotent-Replayed"
+  MaxIdempotencyKeyLength = 255
+)
+
 type JobService interface {
-  CreateJob(ctx context.Context, name string, score float64) (string, error)
-  GetJob(ctx context.Context) (*models.Job, error)
+  CreateJob(ctx context.Context, req *models.JobRequest) (string, error)
+  GetJob(ctx context.Context, lease time.Duration) (*models.Job, error)
   GetJobStatus(ctx context.Context, jobID string) (string, error)
+  GetJobResult(ctx context.Context, jobID string) ([]byte, error)
+  CancelJob(ctx context.Context, jobID, reason string) (string, error)
+  CreateJobIdempotent(ctx context.Context, key string, ttl time.Duration,
+    req *models.JobRequest) (*models.IdempotencyRecord, bool, error)
 }
 
 type JobHandler struct {
   jobSvc JobService
+  cfg    *config.Server
 }
 
-func NewJobHandler(jobSvc JobService) *JobHandler {
+func NewJobHandler(ctx context.Context, jobSvc JobService) *JobHandler {
   return &JobHandler{
     jobSvc: jobSvc,
+    cfg:    config.FromServerContext(ctx),
   }
 }
 
@@ -39,27 +57,104 @@ func (h *JobHandler) CreateJob(w http.ResponseWriter, r *http.Request) {
     }
   }()
 
+  // тело ограничиваем с запасом под остальные поля, сам payload проверяется отдельно
+  r.Body = http.MaxBytesReader(w, r.Body, h.cfg.MaxPayloadSize+MaxRequestOverhead)
+
   var req models.JobRequest
   if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
     wrapped := errors.Wrap(err, errs.ErrDecodeBody)
     log.Error().Err(wrapped).Msg(wrapped.Error())
+
+    var maxBytesErr *http.MaxBytesError
+    if errors.As(err, &maxBytesErr) {
+      http.Error(w, errs.ErrPayloadTooLarge, http.StatusRequestEntityTooLarge)
+      return
+    }
+
     http.Error(w, wrapped.Error(), http.StatusBadRequest)
     return
   }
 
-  id, err := h.jobSvc.CreateJob(r.Context(), req.Name, req.Score)
+  if int64(len(req.Payload)) > h.cfg.MaxPayloadSize {
+    log.Error().Str("name", req.Name).Int("size", len(req.Payload)).Msg(errs.ErrPayloadTooLarge)
+    http.Error(w, errs.ErrPayloadTooLarge, http.StatusRequestEntityTooLarge)
+    return
+  }
+
+  if key := r.Header.Get(IdempotencyKeyHeader); key != "" {
+    h.createJobIdempotent(w, r, key, &req)
+    return
+  }
+
+  id, err := h.jobSvc.CreateJob(r.Context(), &req)
+  // повторная уникальная джоба не ошибка: клиент получает id уже существующей
+  var duplicate *service.DuplicateJobError
+  if errors.As(err, &duplicate) {
+    writeCreateJobResponse(w, service.StatusExists, duplicate.ID)
+    return
+  }
+  if invalidJobRequest(err) {
+    http.Error(w, err.Error(), http.StatusBadRequest)
+    return
+  }
   if err != nil {
     log.Error().Err(err).Msg(err.Error())
     http.Error(w, err.Error(), http.StatusInternalServerError)
     return
   }
 
-  w.WriteHeader(http.StatusAccepted)
-  err = json.NewEncoder(w).Encode(datastructures.CreateJobResponse{Status: "created", ID: id})
+  writeCreateJobResponse(w, service.StatusCreated, id)
+}
+
+// createJobIdempotent создаёт джобу с Idempotency-Key. повтор запроса получает исходный ответ с заголовком
+// Idempotent-Replayed, тот же ключ с другим телом - 422
+func (h *JobHandler) createJobIdempotent(w http.ResponseWriter, r *http.Request, key string, req *models.JobRequest) {
+  if len(key) > MaxIdempotencyKeyLength {
+    http.Error(w, errs.ErrIdempotencyKeyTooLong, http.StatusBadRequest)
+    return
+  }
+
+  record, replayed, err := h.jobSvc.CreateJobIdempotent(r.Context(), key, h.cfg.IdempotencyTTL, req)
+  switch {
+  case errors.Is(err, service.ErrIdempotencyMismatch):
+    http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+    return
+  case errors.Is(err, service.ErrIdempotencyInProgress):
+    http.Error(w, err.Error(), http.StatusConflict)
+    return
+  case invalidJobRequest(err):
+    http.Error(w, err.Error(), http.StatusBadRequest)
+    return
+  case err != nil:
+    log.Error().Err(err).Msg(err.Error())
+    http.Error(w, err.Error(), http.StatusInternalServerError)
+    return
+  }
+
+  if replayed {
+    w.Header().Set(IdempotentReplayHeader, "true")
+  }
+  writeCreateJobResponse(w, record.Status, record.JobID)
+}
+
+func invalidJobRequest(err error) bool {
+  return errors.Is(err, service.ErrInvalidSchedule) || errors.Is(err, service.ErrInvalidDependency) ||
+    errors.Is(err, service.ErrDependencyNotFound) || errors.Is(err, service.ErrInvalidUnique)
+}
+
+// writeCreateJobResponse отвечает 202 на новую джобу и 200, если вместо неё вернули уже существующую
+func writeCreateJobResponse(w http.ResponseWriter, status, id string) {
+  code := http.StatusAccepted
+  if status == service.StatusExists {
+    code = http.StatusOK
+  }
+
+  w.Header().Set("Content-Type", "application/json")
+  w.WriteHeader(code)
+  err := json.NewEncoder(w).Encode(datastructures.CreateJobResponse{Status: status, ID: id})
   if err != nil {
     wrapped := errors.Wrap(err, errs.ErrEncodeResp)
-    log.Error().Err(wrapped).Msg(err.Error())
-    http.Error(w, wrapped.Error(), http.StatusInternalServerError)
+    log.Error().Err(wrapped).Msg(wrapped.Error())
   }
 }
 
@@ -80,3 +175,55 @@ func (h *JobHandler) GetJobStatus(w http.ResponseWriter, r *http.Request) {
     http.Error(w, wrapped.Error(), http.StatusInternalServerError)
   }
 }
+
+func (h *JobHandler) GetJobResult(w http.ResponseWriter, r *http.Request) {
+  jobID := chi.URLParam(r, "job_id")
+
+  result, err := h.jobSvc.GetJobResult(r.Context(), jobID)
+  switch {
+  case errors.Is(err, service.ErrJobNotFound), errors.Is(err, service.ErrResultNotReady),
+    errors.Is(err, service.ErrResultNotFound):
+    http.Error(w, err.Error(), http.StatusNotFound)
+    return
+  case errors.Is(err, service.ErrNoResult):
+    http.Error(w, err.Error(), http.StatusConflict)
+    return
+  case err != nil:
+    log.Error().Err(err).Msg(err.Error())
+    http.Error(w, err.Error(), http.StatusInternalServerError)
+    return
+  }
+
+  w.Header().Set("Content-Type", "application/json")
+  _, err = w.Write(result)
+  if err != nil {
+    wrapped := errors.Wrap(err, errs.ErrWriteResult)
+    log.Error().Err(wrapped).Msg(wrapped.Error())
+    http.Error(w, wrapped.Error(), http.StatusInternalServerError)
+  }
+}
+
+// CancelJob отменяет джобу. причину можно передать в параметре reason, она сохраняется в cancel_reason
+func (h *JobHandler) CancelJob(w http.ResponseWriter, r *http.Request) {
+  jobID := chi.URLParam(r, "job_id")
+  reason := r.URL.Query().Get("reason")
+  if reason == "" {
+    reason = DefaultCancelReason
+  }
+
+  previous, err := h.jobSvc.CancelJob(r.Context(), jobID, reason)
+  switch {
+  case errors.Is(err, service.ErrJobNotFound):
+    http.Error(w, err.Error(), http.StatusNotFound)
+    return
+  case errors.Is(err, service.ErrIllegalTransition):
+    http.Error(w, err.Error(), http.StatusConflict)
+    return
+  case err != nil:
+    log.Error().Err(err).Msg(err.Error())
+    http.Error(w, err.Error(), http.StatusInternalServerError)
+    return
+  }
+
+  writeJSON(w, datastructures.CancelJobResponse{Status: "cancelled", ID: jobID, PreviousStatus: previous})
+}
@@ -1,14 +1,27 @@
 package http
 
 import (
+  "context"
+  "encoding/json"
   "net/http"
 
+  "flussonic_tz/internal/datastructures"
+  errs "flussonic_tz/internal/errors"
+  "flussonic_tz/models"
+  "flussonic_tz/workerpool"
+
+  "github.com/pkg/errors"
   "github.com/rs/zerolog/log"
 )
 
 type WorkerPoolService interface {
   Pause()
   Unpause()
+  Stuck() []models.StuckJob
+  Stats() models.PoolStats
+  Resize(n int) error
+  Autoscaler() models.AutoscalerStats
+  Instances(ctx context.Context) ([]models.Instance, error)
 }
 
 type WorkerPoolHandler struct {
@@ -40,3 +53,87 @@ func (h *WorkerPoolHandler) Unpause(w http.ResponseWriter, r *http.Request) {
     http.Error(w, err.Error(), http.StatusInternalServerError)
   }
 }
+
+func (h *WorkerPoolHandler) Stuck(w http.ResponseWriter, r *http.Request) {
+  w.Header().Set("Content-Type", "application/json")
+  err := json.NewEncoder(w).Encode(h.wpSvc.Stuck())
+  if err != nil {
+    wrapped := errors.Wrap(err, errs.ErrEncodeResp)
+    log.Error().Err(wrapped).Msg(wrapped.Error())
+    http.Error(w, wrapped.Error(), http.StatusInternalServerError)
+  }
+}
+
+func (h *WorkerPoolHandler) Stats(w http.ResponseWriter, r *http.Request) {
+  w.Header().Set("Content-Type", "application/json")
+  err := json.NewEncoder(w).Encode(h.wpSvc.Stats())
+  if err != nil {
+    wrapped := errors.Wrap(err, errs.ErrEncodeResp)
+    log.Error().Err(wrapped).Msg(wrapped.Error())
+    http.Error(w, wrapped.Error(), http.StatusInternalServerError)
+  }
+}
+
+func (h *WorkerPoolHandler) Autoscaler(w http.ResponseWriter, r *http.Request) {
+  w.Header().Set("Content-Type", "application/json")
+  err := json.NewEncoder(w).Encode(h.wpSvc.Autoscaler())
+  if err != nil {
+    wrapped := errors.Wrap(err, errs.ErrEncodeResp)
+    log.Error().Err(wrapped).Msg(wrapped.Error())
+    http.Error(w, wrapped.Error(), http.StatusInternalServerError)
+  }
+}
+
+func (h *WorkerPoolHandler) Instances(w http.ResponseWriter, r *http.Request) {
+  instances, err := h.wpSvc.Instances(r.Context())
+  if err != nil {
+    log.Error().Err(err).Msg(err.Error())
+    http.Error(w, err.Error(), http.StatusInternalServerError)
+    return
+  }
+
+  w.Header().Set("Content-Type", "application/json")
+  err = json.NewEncoder(w).Encode(instances)
+  if err != nil {
+    wrapped := errors.Wrap(err, errs.ErrEncodeResp)
+    log.Error().Err(wrapped).Msg(wrapped.Error())
+    http.Error(w, wrapped.Error(), http.StatusInternalServerError)
+  }
+}
+
+func (h *WorkerPoolHandler) Resize(w http.ResponseWriter, r *http.Request) {
+  defer func() {
+    err := r.Body.Close()
+    if err != nil {
+      wrapped := errors.Wrap(err, errs.ErrCloseBody)
+      log.Error().Err(wrapped).Msg(wrapped.Error())
+    }
+  }()
+
+  var req datastructures.ResizeRequest
+  if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+    wrapped := errors.Wrap(err, errs.ErrDecodeBody)
+    log.Error().Err(wrapped).Msg(wrapped.Error())
+    http.Error(w, wrapped.Error(), http.StatusBadRequest)
+    return
+  }
+
+  if err := h.wpSvc.Resize(req.Workers); err != nil {
+    log.Error().Err(err).Msg(err.Error())
+    // остановленный пул не масштабируется, запрос корректный, но не подходит текущему состоянию пула
+    if errors.Is(err, workerpool.ErrPoolStopped) {
+      http.Error(w, err.Error(), http.StatusConflict)
+      return
+    }
+    http.Error(w, err.Error(), http.StatusBadRequest)
+    return
+  }
+
+  w.Header().Set("Content-Type", "application/json")
+  err := json.NewEncoder(w).Encode(datastructures.ResizeRequest{Workers: req.Workers})
+  if err != nil {
+    wrapped := errors.Wrap(err, errs.ErrEncodeResp)
+    log.Error().Err(wrapped).Msg(wrapped.Error())
+    http.Error(w, wrapped.Error(), http.StatusInternalServerError)
+  }
+}
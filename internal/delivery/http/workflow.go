@@ -0,0 +1,116 @@
+package http
+
+import (
+  "context"
+  "encoding/json"
+  "net/http"
+
+  "flussonic_tz/config"
+  "flussonic_tz/internal/datastructures"
+  errs "flussonic_tz/internal/errors"
+  "flussonic_tz/internal/service"
+  "flussonic_tz/models"
+
+  "github.com/go-chi/chi"
+  "github.com/pkg/errors"
+  "github.com/rs/zerolog/log"
+)
+
+type WorkflowService interface {
+  CreateWorkflow(ctx context.Context, req *models.WorkflowRequest) (*models.Workflow, error)
+  GetWorkflow(ctx context.Context, workflowID string) (*models.Workflow, error)
+}
+
+type WorkflowHandler struct {
+  workflowSvc WorkflowService
+  cfg         *config.Server
+}
+
+func NewWorkflowHandler(ctx context.Context, workflowSvc WorkflowService) *WorkflowHandler {
+  return &WorkflowHandler{
+    workflowSvc: workflowSvc,
+    cfg:         config.FromServerContext(ctx),
+  }
+}
+
+func (h *WorkflowHandler) Create(w http.ResponseWriter, r *http.Request) {
+  defer func() {
+    err := r.Body.Close()
+    if err != nil {
+      wrapped := errors.Wrap(err, errs.ErrCloseBody)
+      log.Error().Err(wrapped).Msg(wrapped.Error())
+    }
+  }()
+
+  // max_payload_size ограничивает сумму payload всех джоб workflow
+  r.Body = http.MaxBytesReader(w, r.Body, h.cfg.MaxPayloadSize+MaxRequestOverhead*int64(h.cfg.MaxWorkflowJobs))
+
+  var req models.WorkflowRequest
+  if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+    wrapped := errors.Wrap(err, errs.ErrDecodeBody)
+    log.Error().Err(wrapped).Msg(wrapped.Error())
+
+    var maxBytesErr *http.MaxBytesError
+    if errors.As(err, &maxBytesErr) {
+      http.Error(w, errs.ErrPayloadTooLarge, http.StatusRequestEntityTooLarge)
+      return
+    }
+
+    http.Error(w, wrapped.Error(), http.StatusBadRequest)
+    return
+  }
+
+  if len(req.Jobs) > h.cfg.MaxWorkflowJobs {
+    http.Error(w, errs.ErrTooManyJobs, http.StatusBadRequest)
+    return
+  }
+  var payloadSize int64
+  for _, job := range req.Jobs {
+    payloadSize += int64(len(job.Payload))
+  }
+  if payloadSize > h.cfg.MaxPayloadSize {
+    log.Error().Int("jobs", len(req.Jobs)).Int64("size", payloadSize).Msg(errs.ErrPayloadTooLarge)
+    http.Error(w, errs.ErrPayloadTooLarge, http.StatusRequestEntityTooLarge)
+    return
+  }
+
+  workflow, err := h.workflowSvc.CreateWorkflow(r.Context(), &req)
+  if errors.Is(err, service.ErrInvalidWorkflow) || errors.Is(err, service.ErrInvalidDependency) ||
+    errors.Is(err, service.ErrInvalidSchedule) {
+    http.Error(w, err.Error(), http.StatusBadRequest)
+    return
+  }
+  if err != nil {
+    log.Error().Err(err).Msg(err.Error())
+    http.Error(w, err.Error(), http.StatusInternalServerError)
+    return
+  }
+
+  resp := datastructures.CreateWorkflowResponse{Status: "created", ID: workflow.ID, Jobs: make(map[string]string)}
+  for _, job := range workflow.Jobs {
+    resp.Jobs[job.Key] = job.ID
+  }
+
+  w.Header().Set("Content-Type", "application/json")
+  w.WriteHeader(http.StatusAccepted)
+  err = json.NewEncoder(w).Encode(resp)
+  if err != nil {
+    wrapped := errors.Wrap(err, errs.ErrEncodeResp)
+    log.Error().Err(wrapped).Msg(wrapped.Error())
+  }
+}
+
+func (h *WorkflowHandler) Get(w http.ResponseWriter, r *http.Request) {
+  workflow, err := h.workflowSvc.GetWorkflow(r.Context(), chi.URLParam(r, "workflow_id"))
+  if errors.Is(err, service.ErrWorkflowNotFound) {
+    http.Error(w, err.Error(), http.StatusNotFound)
+    return
+  }
+  if err != nil {
+    log.Error().Err(err).Msg(err.Error())
+    http.Error(w, err.Error(), http.StatusInternalServerError)
+    return
+  }
+
+  writeJSON(w, workflow)
+}
@@ -17,6 +17,47 @@ const (
   ErrUpdateJob          = "Error updating job"
   ErrGetJobStatus       = "Error getting job status"
   ErrUnmarshalJobStatus = "Error unmarshalling job status"
+  ErrRenewLease         = "Error renewing job lease"
+  ErrCancelJob          = "Error cancelling job"
+  ErrWatchCancellations = "Error watching job cancellations"
+  ErrIllegalTransition  = "Illegal job status transition"
+  ErrQueueEmpty         = "Queue is empty"
+  ErrWaitForJob         = "Error waiting for job"
+  ErrMaxRedeliveries    = "Max redeliveries exceeded"
+  ErrQueueDepth         = "Error getting queue depth"
+  ErrGetJobResult       = "Error getting job result"
+  ErrResultTooLarge     = "Job result too large"
+  ErrJobNotFound        = "Job not found"
+  ErrResultNotReady     = "Job result not ready"
+  ErrResultNotFound     = "Job result expired or empty"
+  ErrNoResult           = "Job finished without result"
+  ErrListInstances      = "Error listing worker pool instances"
+  ErrMarshalInstance    = "Error marshalling worker pool instance"
+  ErrListDeadJobs       = "Error listing dead letter queue"
+  ErrGetDeadJob         = "Error getting dead job"
+  ErrReplayDeadJobs     = "Error replaying dead jobs"
+  ErrPurgeDeadJobs      = "Error purging dead jobs"
+  ErrNotInDeadLetter    = "Job not in dead letter queue"
+  ErrReplayRejected     = "Dead job can't be replayed yet"
+  ErrInvalidSchedule    = "Invalid job schedule"
+  ErrCronTick           = "Error updating cron tick"
+  ErrInvalidDependency  = "Invalid job dependency"
+  ErrDependencyNotFound = "Dependency job not found"
+  ErrSaveWorkflow       = "Error saving workflow"
+  ErrGetWorkflow        = "Error getting workflow"
+  ErrWorkflowNotFound   = "Workflow not found"
+  ErrInvalidWorkflow    = "Invalid workflow"
+  ErrAddBatch           = "Error adding batch"
+  ErrGetBatch           = "Error getting batch"
+  ErrBatchNotFound      = "Batch not found"
+  ErrInvalidBatch       = "Invalid batch"
+  ErrInvalidUnique      = "Invalid job uniqueness"
+  ErrDuplicateJob       = "Duplicate of unique job"
+  ErrIdempotencyKey     = "Error storing idempotency key"
+  ErrFingerprintRequest = "Error fingerprinting request"
+
+  ErrIdempotencyMismatch   = "Idempotency key reused with different request"
+  ErrIdempotencyInProgress = "Request with this idempotency key is in progress"
 )
 
 // pkg/generator
@@ -26,16 +67,61 @@ const (
 
 // delivery/http/job
 const (
-  ErrCloseBody   = "Error closing body"
-  ErrDecodeBody  = "Error decoding body"
-  ErrEncodeResp  = "Error encoding response"
-  ErrWriteStatus = "Error writing status"
+  ErrCloseBody       = "Error closing body"
+  ErrDecodeBody      = "Error decoding body"
+  ErrEncodeResp      = "Error encoding response"
+  ErrWriteStatus     = "Error writing status"
+  ErrWriteResult     = "Error writing result"
+  ErrPayloadTooLarge = "Payload too large"
+  ErrTooManyJobs     = "Too many jobs in request"
+
+  ErrIdempotencyKeyTooLong = "Idempotency key too long"
+
+  ErrInvalidPagination = "Invalid offset or limit"
+  ErrNoJobIDs          = "No job ids"
 )
 
 // workerpool
 const (
-  ErrCompleteJob = "Error change job status to complete"
-  ErrFailJob     = "Error change job status to fail"
+  ErrCompleteJob   = "Error change job status to complete"
+  ErrFailJob       = "Error change job status to fail"
+  ErrUnknownJob    = "Unknown job type"
+  ErrDecodePayload = "Error decoding job payload"
+  ErrJobCancelled  = "Job cancelled"
+  ErrPoolStopped   = "Worker pool stopped"
+
+  ErrHandlerIgnoresCancel = "Handler ignores cancellation"
+  ErrCreateRateLimiter    = "Error creating rate limiter"
+  ErrRetryJob             = "Error scheduling job retry"
+  ErrPromoteJobs          = "Error promoting delayed jobs"
+  ErrExpireJobs           = "Error expiring jobs"
+  ErrRequeueExpired       = "Error requeueing jobs with expired lease"
+  ErrLeaseLost            = "Job lease lost"
+  ErrInterruptJob         = "Error interrupting job"
+  ErrInvalidWorkers       = "Invalid workers count"
+  ErrAutoscale            = "Error autoscaling worker pool"
+  ErrInvalidAutoscale     = "Invalid autoscale config"
+  ErrJobPanicked          = "Job handler panicked"
+  ErrRecordPanic          = "Error recording job panic"
+  ErrEncodeResult         = "Error encoding job result"
+  ErrReportProgress       = "Error reporting job progress"
+  ErrGetHostname          = "Error getting hostname"
+  ErrRegisterInstance     = "Error registering worker pool instance"
+  ErrUnregisterInstance   = "Error unregistering worker pool instance"
+)
+
+// pkg/ratelimit
+const (
+  ErrInvalidRateLimit     = "Invalid rate limit"
+  ErrUnknownRateAlgorithm = "Unknown rate limit algorithm"
+)
+
+// internal/scheduler
+const (
+  ErrInvalidCronJob    = "Invalid cron job"
+  ErrInvalidCronConfig = "Invalid cron config"
+  ErrEnqueueCronJob    = "Error enqueueing cron job"
+  ErrScheduleCronJob   = "Error scheduling cron job"
 )
 
 // internal/app/server
@@ -0,0 +1,102 @@
+package repository
+
+import (
+  "context"
+  "strconv"
+  "time"
+
+  errs "flussonic_tz/internal/errors"
+  "flussonic_tz/internal/service"
+  "flussonic_tz/models"
+
+  "github.com/go-redis/redis/v8"
+  "github.com/pkg/errors"
+  "github.com/rs/zerolog/log"
+)
+
+const (
+  BatchPrefix = "batch:"
+)
+
+// AddBatch одной транзакцией сохраняет счётчики пачки, callback джобу, которая ждёт в статусе waiting, и сами
+// джобы. пачка либо добавляется целиком, либо не добавляется вовсе, иначе pending никогда не дошёл бы до нуля
+func (r *RedisRepository) AddBatch(ctx context.Context, batch *models.Batch, jobs []*models.Job, callback *models.Job) error {
+  fields := map[string]interface{}{
+    "total":      len(jobs),
+    "pending":    len(jobs),
+    "succeeded":  0,
+    "failed":     0,
+    "cancelled":  0,
+    "expired":    0,
+    "queue":      r.queueName,
+    "created_at": batch.CreatedAt.Format(time.RFC3339),
+  }
+
+  var callbackFields map[string]interface{}
+  if callback != nil {
+    var err error
+    if callbackFields, err = jobFields(callback); err != nil {
+      return err
+    }
+    callbackFields["status"] = StatusWaiting
+    callbackFields["callback_for"] = batch.ID
+    fields["callback_id"] = callback.ID
+  }
+
+  err := r.addJobsTx(ctx, jobs, func(pipe redis.Pipeliner) {
+    if callback != nil {
+      pipe.HSet(ctx, taskKey(callback.ID), callbackFields)
+    }
+    pipe.HSet(ctx, batchKey(batch.ID), fields)
+  })
+  if err != nil {
+    wrapped := errors.Wrap(err, errs.ErrAddBatch)
+    log.Error().Err(wrapped).Msg(wrapped.Error())
+    return wrapped
+  }
+
+  return nil
+}
+
+func (r *RedisRepository) GetBatch(ctx context.Context, batchID string) (*models.Batch, error) {
+  fields, err := r.client.HGetAll(ctx, batchKey(batchID)).Result()
+  if err != nil {
+    wrapped := errors.Wrap(err, errs.ErrGetBatch)
+    log.Error().Err(wrapped).Msg(wrapped.Error())
+    return nil, wrapped
+  }
+  if len(fields) == 0 {
+    return nil, service.ErrBatchNotFound
+  }
+
+  batch := &models.Batch{
+    ID:         batchID,
+    CallbackID: fields["callback_id"],
+  }
+  counters := map[string]*int{
+    "total":     &batch.Total,
+    "pending":   &batch.Pending,
+    "succeeded": &batch.Succeeded,
+    "failed":    &batch.Failed,
+    "cancelled": &batch.Cancelled,
+    "expired":   &batch.Expired,
+  }
+  for field, counter := range counters {
+    if *counter, err = strconv.Atoi(fields[field]); err != nil {
+      wrapped := errors.Wrap(err, errs.ErrGetBatch)
+      log.Error().Err(wrapped).Msg(wrapped.Error())
+      return nil, wrapped
+    }
+  }
+  if batch.CreatedAt, err = time.Parse(time.RFC3339, fields["created_at"]); err != nil {
+    wrapped := errors.Wrap(err, errs.ErrGetBatch)
+    log.Error().Err(wrapped).Msg(wrapped.Error())
+    return nil, wrapped
+  }
+
+  return batch, nil
+}
+
+func batchKey(batchID string) string {
+  return BatchPrefix + batchID
+}
@@ -0,0 +1,72 @@
+package repository
+
+import (
+  "context"
+  "strconv"
+  "time"
+
+  errs "flussonic_tz/internal/errors"
+
+  "github.com/go-redis/redis/v8"
+  "github.com/pkg/errors"
+  "github.com/rs/zerolog/log"
+)
+
+// CronLastTick возвращает последний тик периодической джобы, который уже забрал какой-то инстанс.
+// false - джоба ещё ни разу не планировалась
+func (r *RedisRepository) CronLastTick(ctx context.Context, name string) (time.Time, bool, error) {
+  value, err := r.client.Get(ctx, r.cronKey(name)).Int64()
+  if errors.Is(err, redis.Nil) {
+    return time.Time{}, false, nil
+  }
+  if err != nil {
+    wrapped := errors.Wrap(err, errs.ErrCronTick)
+    log.Error().Err(wrapped).Msg(wrapped.Error())
+    return time.Time{}, false, wrapped
+  }
+
+  return time.UnixMilli(value), true, nil
+}
+
+// InitCronTick запоминает время, с которого считаются тики новой периодической джобы, если его ещё нет
+func (r *RedisRepository) InitCronTick(ctx context.Context, name string, tick time.Time) error {
+  err := r.client.SetNX(ctx, r.cronKey(name), tick.UnixMilli(), 0).Err()
+  if err != nil {
+    wrapped := errors.Wrap(err, errs.ErrCronTick)
+    log.Error().Err(wrapped).Msg(wrapped.Error())
+    return wrapped
+  }
+
+  return nil
+}
+
+// ClaimCronTick забирает тик периодической джобы. тик достаётся только одному инстансу и только если он позже
+// уже забранных, поэтому на каждый тик ставится не больше одной джобы
+func (r *RedisRepository) ClaimCronTick(ctx context.Context, name string, tick time.Time) (bool, error) {
+  claimed, err := claimTickScript.Run(ctx, r.client, []string{r.cronKey(name)}, strconv.FormatInt(tick.UnixMilli(), 10)).Int()
+  if err != nil {
+    wrapped := errors.Wrap(err, errs.ErrCronTick)
+    log.Error().Err(wrapped).Msg(wrapped.Error())
+    return false, wrapped
+  }
+
+  return claimed == 1, nil
+}
+
+// ReleaseCronTick возвращает курсор на previous, если тик забрали, а джобу поставить не удалось. курсор не трогается,
+// если за это время его уже передвинул другой инстанс
+func (r *RedisRepository) ReleaseCronTick(ctx context.Context, name string, tick, previous time.Time) error {
+  err := releaseTickScript.Run(ctx, r.client, []string{r.cronKey(name)}, strconv.FormatInt(tick.UnixMilli(), 10),
+    strconv.FormatInt(previous.UnixMilli(), 10)).Err()
+  if err != nil {
+    wrapped := errors.Wrap(err, errs.ErrCronTick)
+    log.Error().Err(wrapped).Msg(wrapped.Error())
+    return wrapped
+  }
+
+  return nil
+}
+
+func (r *RedisRepository) cronKey(name string) string {
+  return r.queueName + ":cron:" + name
+}
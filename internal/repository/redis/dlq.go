@@ -0,0 +1,181 @@
+package repository
+
+import (
+  "context"
+  "encoding/json"
+  "strconv"
+  "time"
+
+  errs "flussonic_tz/internal/errors"
+  "flussonic_tz/internal/service"
+  "flussonic_tz/models"
+
+  "github.com/go-redis/redis/v8"
+  "github.com/pkg/errors"
+  "github.com/rs/zerolog/log"
+)
+
+const (
+  PurgeBatchSize = 500
+)
+
+// ListDeadJobs возвращает страницу dead letter queue, начиная с последних упавших джоб
+func (r *RedisRepository) ListDeadJobs(ctx context.Context, offset, limit int) (*models.DeadJobsPage, error) {
+  pipe := r.client.Pipeline()
+  totalCmd := pipe.ZCard(ctx, r.deadName())
+  idsCmd := pipe.ZRevRangeWithScores(ctx, r.deadName(), int64(offset), int64(offset+limit-1))
+  if _, err := pipe.Exec(ctx); err != nil {
+    wrapped := errors.Wrap(err, errs.ErrListDeadJobs)
+    log.Error().Err(wrapped).Msg(wrapped.Error())
+    return nil, wrapped
+  }
+
+  entries := idsCmd.Val()
+  fieldsCmds := make([]*redis.SliceCmd, 0, len(entries))
+  pipe = r.client.Pipeline()
+  for _, entry := range entries {
+    fieldsCmds = append(fieldsCmds, pipe.HMGet(ctx, taskKey(entry.Member.(string)), "name", "error", "attempt"))
+  }
+  if len(entries) > 0 {
+    if _, err := pipe.Exec(ctx); err != nil {
+      wrapped := errors.Wrap(err, errs.ErrListDeadJobs)
+      log.Error().Err(wrapped).Msg(wrapped.Error())
+      return nil, wrapped
+    }
+  }
+
+  page := &models.DeadJobsPage{
+    Total:  totalCmd.Val(),
+    Offset: offset,
+    Limit:  limit,
+    Jobs:   make([]models.DeadJob, 0, len(entries)),
+  }
+  for i, entry := range entries {
+    fields := fieldsCmds[i].Val()
+    job := models.DeadJob{
+      ID:     entry.Member.(string),
+      DeadAt: time.UnixMilli(int64(entry.Score)),
+    }
+    job.Name, _ = fields[0].(string)
+    job.Error, _ = fields[1].(string)
+    if attempt, ok := fields[2].(string); ok {
+      job.Attempts, _ = strconv.Atoi(attempt)
+    }
+    page.Jobs = append(page.Jobs, job)
+  }
+
+  return page, nil
+}
+
+// GetDeadJob возвращает джобу из dead letter queue вместе с payload и историей попыток
+func (r *RedisRepository) GetDeadJob(ctx context.Context, jobID string) (*models.DeadJob, error) {
+  pipe := r.client.Pipeline()
+  scoreCmd := pipe.ZScore(ctx, r.deadName(), jobID)
+  fieldsCmd := pipe.HGetAll(ctx, taskKey(jobID))
+  historyCmd := pipe.LRange(ctx, attemptsKey(jobID), 0, -1)
+  _, err := pipe.Exec(ctx)
+  if errors.Is(err, redis.Nil) {
+    return nil, service.ErrNotInDeadLetter
+  }
+  if err != nil {
+    wrapped := errors.Wrap(err, errs.ErrGetDeadJob)
+    log.Error().Err(wrapped).Msg(wrapped.Error())
+    return nil, wrapped
+  }
+
+  fields := fieldsCmd.Val()
+  job := &models.DeadJob{
+    ID:      jobID,
+    Name:    fields["name"],
+    Error:   fields["error"],
+    DeadAt:  time.UnixMilli(int64(scoreCmd.Val())),
+    Payload: json.RawMessage(fields["payload"]),
+    History: make([]models.AttemptRecord, 0, len(historyCmd.Val())),
+  }
+  job.Attempts, _ = strconv.Atoi(fields["attempt"])
+  for _, data := range historyCmd.Val() {
+    var record models.AttemptRecord
+    if err = json.Unmarshal([]byte(data), &record); err != nil {
+      wrapped := errors.Wrap(err, errs.ErrGetDeadJob)
+      log.Error().Err(wrapped).Msg(wrapped.Error())
+      return nil, wrapped
+    }
+    job.History = append(job.History, record)
+  }
+
+  return job, nil
+}
+
+// ReplayDeadJobs возвращает джобы из dead letter queue в основную очередь. джобы, которых в ней нет, пропускаются,
+// а джобы, которые пока нельзя перезапустить, возвращаются вместе с причиной
+func (r *RedisRepository) ReplayDeadJobs(ctx context.Context, jobIDs []string) (int, []models.ReplayRejection, error) {
+  args := []interface{}{TaskPrefix, time.Now().Format(time.RFC3339)}
+  for _, id := range jobIDs {
+    args = append(args, id)
+  }
+
+  result, err := replayScript.Run(ctx, r.client, []string{r.deadName(), r.queueName, r.signalName()}, args...).Slice()
+  if err != nil {
+    wrapped := errors.Wrap(err, errs.ErrReplayDeadJobs)
+    log.Error().Err(wrapped).Msg(wrapped.Error())
+    return 0, nil, wrapped
+  }
+
+  replayed, _ := result[0].(int64)
+  pairs, _ := result[1].([]interface{})
+  rejected := make([]models.ReplayRejection, 0, len(pairs)/2)
+  for i := 0; i+1 < len(pairs); i += 2 {
+    id, _ := pairs[i].(string)
+    reason, _ := pairs[i+1].(string)
+    rejected = append(rejected, models.ReplayRejection{ID: id, Reason: reason})
+  }
+
+  return int(replayed), rejected, nil
+}
+
+// PurgeDeadJobs удаляет джобы из dead letter queue вместе с их данными. без id очищается вся очередь
+func (r *RedisRepository) PurgeDeadJobs(ctx context.Context, jobIDs []string) (int, error) {
+  if len(jobIDs) > 0 {
+    return r.purge(ctx, jobIDs)
+  }
+
+  // всю очередь чистим пачками, чтобы не блокировать redis одним большим скриптом
+  total := 0
+  for {
+    ids, err := r.client.ZRange(ctx, r.deadName(), 0, PurgeBatchSize-1).Result()
+    if err != nil {
+      wrapped := errors.Wrap(err, errs.ErrPurgeDeadJobs)
+      log.Error().Err(wrapped).Msg(wrapped.Error())
+      return total, wrapped
+    }
+    if len(ids) == 0 {
+      return total, nil
+    }
+
+    purged, err := r.purge(ctx, ids)
+    total += purged
+    if err != nil {
+      return total, err
+    }
+  }
+}
+
+func (r *RedisRepository) purge(ctx context.Context, jobIDs []string) (int, error) {
+  args := []interface{}{TaskPrefix, AttemptsSuffix, ResultSuffix}
+  for _, id := range jobIDs {
+    args = append(args, id)
+  }
+
+  purged, err := purgeScript.Run(ctx, r.client, []string{r.deadName()}, args...).Int()
+  if err != nil {
+    wrapped := errors.Wrap(err, errs.ErrPurgeDeadJobs)
+    log.Error().Err(wrapped).Msg(wrapped.Error())
+    return 0, wrapped
+  }
+
+  return purged, nil
+}
+
+func (r *RedisRepository) deadName() string {
+  return r.queueName + ":dead"
+}
@@ -0,0 +1,44 @@
+package repository
+
+import (
+  "context"
+  "time"
+
+  errs "flussonic_tz/internal/errors"
+  "flussonic_tz/models"
+
+  "github.com/pkg/errors"
+  "github.com/rs/zerolog/log"
+)
+
+const (
+  ExpireBatchSize = 100
+)
+
+// expiresAtMs возвращает дедлайн джобы для {queue}:expiring, скрипты добавления пишут его вместе с самой джобой.
+// 0 - джоба не истекает
+func expiresAtMs(job *models.Job) int64 {
+  if job.ExpiresAt.IsZero() {
+    return 0
+  }
+  return job.ExpiresAt.UnixMilli()
+}
+
+// ExpireJobs завершает со статусом expired джобы, которые так и не начали выполняться до своего expires_at, и
+// убирает их из очередей. возвращает количество истёкших джоб
+func (r *RedisRepository) ExpireJobs(ctx context.Context, now time.Time) (int, error) {
+  keys := []string{r.expiringName(), r.queueName, r.delayedName(), r.deadName()}
+  expired, err := expireScript.Run(ctx, r.client, keys, TaskPrefix, now.UnixMilli(), ExpireBatchSize,
+    now.Format(time.RFC3339), DependentsSuffix).Int()
+  if err != nil {
+    wrapped := errors.Wrap(err, errs.ErrUpdateJob)
+    log.Error().Err(wrapped).Msg(wrapped.Error())
+    return 0, wrapped
+  }
+
+  return expired, nil
+}
+
+func (r *RedisRepository) expiringName() string {
+  return r.queueName + ":expiring"
+}
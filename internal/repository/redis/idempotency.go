@@ -0,0 +1,92 @@
+package repository
+
+import (
+  "context"
+  "encoding/json"
+  "time"
+
+  errs "flussonic_tz/internal/errors"
+  "flussonic_tz/models"
+
+  "github.com/go-redis/redis/v8"
+  "github.com/pkg/errors"
+  "github.com/rs/zerolog/log"
+)
+
+// ReserveIdempotencyKey атомарно занимает ключ под новый запрос. если ключ уже занят, возвращается сохранённая
+// под ним запись и false
+func (r *RedisRepository) ReserveIdempotencyKey(ctx context.Context, key string, record *models.IdempotencyRecord,
+  ttl time.Duration) (*models.IdempotencyRecord, bool, error) {
+  data, err := json.Marshal(record)
+  if err != nil {
+    wrapped := errors.Wrap(err, errs.ErrIdempotencyKey)
+    log.Error().Err(wrapped).Msg(wrapped.Error())
+    return nil, false, wrapped
+  }
+
+  // ключ может истечь между SETNX и GET, тогда просто пробуем занять его ещё раз
+  for {
+    reserved, err := r.client.SetNX(ctx, r.idempotencyKey(key), data, ttl).Result()
+    if err != nil {
+      wrapped := errors.Wrap(err, errs.ErrIdempotencyKey)
+      log.Error().Err(wrapped).Msg(wrapped.Error())
+      return nil, false, wrapped
+    }
+    if reserved {
+      return record, true, nil
+    }
+
+    stored, err := r.client.Get(ctx, r.idempotencyKey(key)).Bytes()
+    if errors.Is(err, redis.Nil) {
+      continue
+    }
+    if err != nil {
+      wrapped := errors.Wrap(err, errs.ErrIdempotencyKey)
+      log.Error().Err(wrapped).Msg(wrapped.Error())
+      return nil, false, wrapped
+    }
+
+    var existing models.IdempotencyRecord
+    if err = json.Unmarshal(stored, &existing); err != nil {
+      wrapped := errors.Wrap(err, errs.ErrIdempotencyKey)
+      log.Error().Err(wrapped).Msg(wrapped.Error())
+      return nil, false, wrapped
+    }
+
+    return &existing, false, nil
+  }
+}
+
+// SaveIdempotencyKey сохраняет ответ под занятым ключом, ttl отсчитывается заново
+func (r *RedisRepository) SaveIdempotencyKey(ctx context.Context, key string, record *models.IdempotencyRecord,
+  ttl time.Duration) error {
+  data, err := json.Marshal(record)
+  if err != nil {
+    wrapped := errors.Wrap(err, errs.ErrIdempotencyKey)
+    log.Error().Err(wrapped).Msg(wrapped.Error())
+    return wrapped
+  }
+
+  if err = r.client.Set(ctx, r.idempotencyKey(key), data, ttl).Err(); err != nil {
+    wrapped := errors.Wrap(err, errs.ErrIdempotencyKey)
+    log.Error().Err(wrapped).Msg(wrapped.Error())
+    return wrapped
+  }
+
+  return nil
+}
+
+// ReleaseIdempotencyKey освобождает ключ, если джобу создать не удалось, чтобы клиент мог повторить запрос
+func (r *RedisRepository) ReleaseIdempotencyKey(ctx context.Context, key string) error {
+  if err := r.client.Del(ctx, r.idempotencyKey(key)).Err(); err != nil {
+    wrapped := errors.Wrap(err, errs.ErrIdempotencyKey)
+    log.Error().Err(wrapped).Msg(wrapped.Error())
+    return wrapped
+  }
+
+  return nil
+}
+
+func (r *RedisRepository) idempotencyKey(key string) string {
+  return r.queueName + ":idempotency:" + key
+}
@@ -0,0 +1,76 @@
+package repository
+
+import (
+  "encoding/json"
+  "strconv"
+  "time"
+
+  "flussonic_tz/models"
+
+  "github.com/pkg/errors"
+)
+
+var jsonFields = []string{"payload", "retry", "depends_on"}
+
+// jobFromHash собирает джобу из полей хэша task:{id}
+func jobFromHash(jobID string, fields map[string]string) (*models.Job, error) {
+  if len(fields) == 0 {
+    return nil, errors.Errorf("job %s not found", jobID)
+  }
+
+  job := &models.Job{
+    ID:     jobID,
+    Name:   fields["name"],
+    Status: fields["status"],
+  }
+
+  var err error
+  if job.Score, err = strconv.ParseFloat(fields["score"], 64); err != nil {
+    return nil, err
+  }
+  if value, ok := fields["attempt"]; ok {
+    if job.Attempt, err = strconv.Atoi(value); err != nil {
+      return nil, err
+    }
+  }
+  if value, ok := fields["delivery"]; ok {
+    if job.Delivery, err = strconv.Atoi(value); err != nil {
+      return nil, err
+    }
+  }
+  if value, ok := fields["max_redeliveries"]; ok {
+    if job.MaxRedeliveries, err = strconv.Atoi(value); err != nil {
+      return nil, err
+    }
+  }
+  if value, ok := fields["redeliveries"]; ok {
+    if job.Redeliveries, err = strconv.Atoi(value); err != nil {
+      return nil, err
+    }
+  }
+  if value, ok := fields["payload"]; ok {
+    job.Payload = json.RawMessage(value)
+  }
+  if value, ok := fields["retry"]; ok {
+    if err = json.Unmarshal([]byte(value), &job.Retry); err != nil {
+      return nil, err
+    }
+  }
+  if value, ok := fields["created_at"]; ok {
+    if job.CreatedAt, err = time.Parse(time.RFC3339, value); err != nil {
+      return nil, err
+    }
+  }
+  if value, ok := fields["started_at"]; ok {
+    if job.StartedAt, err = time.Parse(time.RFC3339, value); err != nil {
+      return nil, err
+    }
+  }
+  if value, ok := fields["expires_at"]; ok {
+    if job.ExpiresAt, err = time.Parse(time.RFC3339, value); err != nil {
+      return nil, err
+    }
+  }
+
+  return job, nil
+}
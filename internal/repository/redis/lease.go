@@ -0,0 +1,51 @@
+package repository
+
+import (
+  "context"
+  "time"
+
+  errs "flussonic_tz/internal/errors"
+
+  "github.com/go-redis/redis/v8"
+  "github.com/pkg/errors"
+  "github.com/rs/zerolog/log"
+)
+
+const (
+  ReapBatchSize = 100
+)
+
+// RenewLease продлевает lease джобы. false означает, что lease уже потерян и джобу забрал reaper
+func (r *RedisRepository) RenewLease(ctx context.Context, jobID string, lease time.Duration) (bool, error) {
+  changed, err := r.client.ZAddArgs(ctx, r.inflightName(), redis.ZAddArgs{
+    XX: true,
+    Ch: true,
+    Members: []redis.Z{{
+      Score:  float64(time.Now().Add(lease).UnixMilli()),
+      Member: jobID,
+    }},
+  }).Result()
+  if err != nil {
+    wrapped := errors.Wrap(err, errs.ErrRenewLease)
+    log.Error().Err(wrapped).Msg(wrapped.Error())
+    return false, wrapped
+  }
+
+  return changed > 0, nil
+}
+
+// RequeueExpired возвращает в очередь джобы с истёкшим lease. если джоба уже возвращалась больше
+// maxRedeliveries раз (или своего max_redeliveries), она считается упавшей
+func (r *RedisRepository) RequeueExpired(ctx context.Context, now time.Time, maxRedeliveries int) (int, int, error) {
+  keys := []string{r.inflightName(), r.queueName, r.deadName(), r.signalName()}
+  result, err := requeueExpiredScript.Run(ctx, r.client, keys, TaskPrefix, now.UnixMilli(), ReapBatchSize,
+    maxRedeliveries, now.Format(time.RFC3339), errs.ErrMaxRedeliveries, AttemptsSuffix, DependentsSuffix,
+  ).Int64Slice()
+  if err != nil {
+    wrapped := errors.Wrap(err, errs.ErrUpdateJob)
+    log.Error().Err(wrapped).Msg(wrapped.Error())
+    return 0, 0, wrapped
+  }
+
+  return int(result[0]), int(result[1]), nil
+}
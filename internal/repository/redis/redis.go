@@ -3,9 +3,10 @@ package repository
 import (
   "context"
   "encoding/json"
-  "fmt"
+  "strings"
   "time"
 
+  "flussonic_tz/config"
   errs "flussonic_tz/internal/errors"
 
   "github.com/pkg/errors"
@@ -18,113 +19,417 @@ import (
 )
 
 const (
-  StatusPending    = "pending"
-  StatusInProgress = "in_progress"
-  StatusCompleted  = "completed"
-  StatusFailed     = "failed"
+  StatusPending     = "pending"
+  StatusInProgress  = "in_progress"
+  StatusCompleted   = "completed"
+  StatusFailed      = "failed"
+  StatusRetrying    = "retrying"
+  StatusCancelled   = "cancelled"
+  StatusInterrupted = "interrupted"
+  StatusScheduled   = "scheduled"
+  StatusWaiting     = "waiting"
+  StatusExpired     = "expired"
+)
+
+const (
+  TaskPrefix           = "task:"
+  ResultSuffix         = ":result"
+  AttemptsSuffix       = ":attempts"
+  DependentsSuffix     = ":dependents"
+  IllegalReplyPrefix   = "ILLEGAL "
+  MissingReplyPrefix   = "MISSING "
+  DuplicateReplyPrefix = "DUPLICATE "
+  PromoteBatchSize     = 100
 )
 
 type RedisRepository struct {
   client    *redis.Client
   queueName string
+  cfg       *config.Redis
 }
 
-func NewRedisRepository(client *redis.Client, queueName string) service.JobRepository {
+func NewRedisRepository(ctx context.Context, client *redis.Client) service.JobRepository {
+  cfg := config.FromRedisContext(ctx)
   return &RedisRepository{
     client:    client,
-    queueName: queueName,
+    queueName: cfg.QueueName,
+    cfg:       cfg,
   }
 }
 
+// jobScript - вызов скрипта, который добавляет джобу. собирается отдельно от запуска, чтобы джобы пачки и workflow
+// можно было добавить одной транзакцией
+type jobScript struct {
+  script *redis.Script
+  keys   []string
+  args   []interface{}
+}
+
 func (r *RedisRepository) AddJob(ctx context.Context, job *models.Job) error {
-  jsonMsg, err := json.Marshal(job)
+  call, err := r.addJobScript(job)
   if err != nil {
-    wrapped := errors.Wrap(err, errs.ErrMarshalJob)
-    log.Error().Err(wrapped).Msg(wrapped.Error())
-    return wrapped
+    return err
   }
 
-  err = r.client.ZAdd(ctx, r.queueName, &redis.Z{
-    Score:  job.Score,
-    Member: jsonMsg,
-  }).Err()
+  state, err := call.script.Run(ctx, r.client, call.keys, call.args...).Result()
   if err != nil {
-    wrapped := errors.Wrap(err, errs.ErrAddJob)
+    var duplicate *service.DuplicateJobError
+    if errors.As(scriptError(err), &duplicate) {
+      return duplicate
+    }
+    wrapped := errors.Wrap(scriptError(err), errs.ErrAddJob)
     log.Error().Err(wrapped).Msg(wrapped.Error())
     return wrapped
   }
 
+  if len(job.DependsOn) > 0 {
+    log.Debug().Str("job_id", job.ID).Interface("state", state).Msg("dependent job added")
+  }
+  return nil
+}
+
+// addJobsTx добавляет джобы одной транзакцией MULTI/EXEC вместе с записями, которые делает prepare, поэтому воркеры
+// не увидят пачку или workflow частично. MULTI/EXEC не откатывает команды, если одна из них упала, поэтому вызывающий
+// должен заранее исключить ошибки скриптов: id джоб новые, unique_key запрещён в пачках и workflow, depends_on - в
+// пачках, а родители джоб workflow добавляются раньше них в той же транзакции. при NOSCRIPT внутри транзакции нельзя
+// повторить вызов через EVAL, поэтому скрипты загружаются заранее
+func (r *RedisRepository) addJobsTx(ctx context.Context, jobs []*models.Job, prepare func(pipe redis.Pipeliner)) error {
+  calls := make([]*jobScript, 0, len(jobs))
+  loaded := make(map[*redis.Script]bool)
+  for _, job := range jobs {
+    call, err := r.addJobScript(job)
+    if err != nil {
+      return err
+    }
+    if !loaded[call.script] {
+      if err = call.script.Load(ctx, r.client).Err(); err != nil {
+        return err
+      }
+      loaded[call.script] = true
+    }
+    calls = append(calls, call)
+  }
+
+  _, err := r.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+    prepare(pipe)
+    for _, call := range calls {
+      call.script.EvalSha(ctx, pipe, call.keys, call.args...)
+    }
+    return nil
+  })
+  if err != nil {
+    return scriptError(err)
+  }
+
+  return nil
+}
+
+// addJobScript выбирает скрипт добавления по виду джобы: с зависимостями, отложенная или обычная
+func (r *RedisRepository) addJobScript(job *models.Job) (*jobScript, error) {
+  status, err := jobFields(job)
+  if err != nil {
+    return nil, err
+  }
+  if job.UniqueKey != "" {
+    status["unique_lock"] = r.uniqueLockName(job.UniqueKey)
+  }
+  if len(job.DependsOn) > 0 {
+    return r.dependentJobScript(job, status)
+  }
+
+  // запланированная джоба ждёт в отложенной очереди вместе с ретраями, пока её не перенесёт promoter
+  script, keys, score := enqueueScript, []string{taskKey(job.ID), r.queueName, r.expiringName(), r.signalName()},
+    job.Score
+  if job.RunAt.After(time.Now()) {
+    status["status"] = StatusScheduled
+    status["run_at"] = job.RunAt.Format(time.RFC3339)
+    script, keys = scheduleScript, []string{taskKey(job.ID), r.delayedName(), r.expiringName()}
+    score = float64(job.RunAt.UnixMilli())
+  }
+
+  args := []interface{}{job.ID, score, expiresAtMs(job)}
+  for field, value := range status {
+    args = append(args, field, value)
+  }
+
+  return &jobScript{script: script, keys: keys, args: args}, nil
+}
+
+// jobFields собирает поля хэша task:{id} для новой джобы
+func jobFields(job *models.Job) (map[string]interface{}, error) {
   status := map[string]interface{}{
     "status":     StatusPending,
+    "name":       job.Name,
     "score":      job.Score,
+    "attempt":    job.Attempt,
     "created_at": time.Now().Format(time.RFC3339),
   }
+  if len(job.Payload) > 0 {
+    status["payload"] = string(job.Payload)
+  }
+  if job.Retry != nil {
+    retryPolicy, err := json.Marshal(job.Retry)
+    if err != nil {
+      wrapped := errors.Wrap(err, errs.ErrMarshalJob)
+      log.Error().Err(wrapped).Msg(wrapped.Error())
+      return nil, wrapped
+    }
+    status["retry"] = string(retryPolicy)
+  }
+  if job.MaxRedeliveries > 0 {
+    status["max_redeliveries"] = job.MaxRedeliveries
+  }
+  if job.WorkflowID != "" {
+    status["workflow_id"] = job.WorkflowID
+  }
+  if job.BatchID != "" {
+    status["batch_id"] = job.BatchID
+  }
+  if job.UniqueKey != "" {
+    status["unique_key"] = job.UniqueKey
+    status["unique_scope"] = job.UniqueScope
+  }
+  if job.UniqueScope == models.UniqueScopeTTL {
+    status["unique_ttl"] = job.UniqueTTL.Milliseconds()
+  }
+  if !job.ExpiresAt.IsZero() {
+    status["expires_at"] = job.ExpiresAt.Format(time.RFC3339)
+  }
 
-  return r.client.HSet(ctx, fmt.Sprintf("task:%s", job.ID), status).Err()
+  return status, nil
 }
 
-func (r *RedisRepository) GetJob(ctx context.Context) (*models.Job, error) {
-  result, err := r.client.ZPopMin(ctx, r.queueName, 1).Result()
+// GetJob забирает самую приоритетную джобу и выдаёт на неё lease. пока lease продлевается, джоба считается занятой,
+// иначе reaper вернёт её в очередь
+func (r *RedisRepository) GetJob(ctx context.Context, lease time.Duration) (*models.Job, error) {
+  now := time.Now()
+  keys := []string{r.queueName, r.inflightName(), r.expiringName(), r.deadName(), r.signalName()}
+  result, err := dequeueScript.Run(ctx, r.client, keys, TaskPrefix, now.Add(lease).UnixMilli(),
+    now.Format(time.RFC3339), now.UnixMilli(), DependentsSuffix).Slice()
+  if errors.Is(err, redis.Nil) {
+    return nil, service.ErrQueueEmpty
+  }
   if err != nil {
     wrapped := errors.Wrap(err, errs.ErrGetJob)
     log.Error().Err(wrapped).Msg(wrapped.Error())
     return nil, wrapped
   }
 
-  if len(result) == 0 {
-    return nil, errors.New("Job not found")
+  jobID, ok := result[0].(string)
+  if !ok {
+    wrapped := errors.New(errs.ErrCastError)
+    log.Error().Err(wrapped).Msg(wrapped.Error())
+    return nil, wrapped
   }
 
-  jsonJob, ok := result[0].Member.(string)
-  if !ok {
+  fields, err := hashFromReply(result[1])
+  if err != nil {
     wrapped := errors.Wrap(err, errs.ErrCastError)
     log.Error().Err(wrapped).Msg(wrapped.Error())
     return nil, wrapped
   }
 
-  var job *models.Job
-  if err = json.Unmarshal([]byte(jsonJob), &job); err != nil {
+  job, err := jobFromHash(jobID, fields)
+  if err != nil {
     wrapped := errors.Wrap(err, errs.ErrUnmarshalJob)
     log.Error().Err(wrapped).Msg(wrapped.Error())
     return nil, wrapped
   }
 
-  err = r.client.HSet(ctx, fmt.Sprintf("task:%s", job.ID), map[string]interface{}{
-    "status":     StatusInProgress,
-    "started_at": time.Now().Format(time.RFC3339),
-  }).Err()
+  return job, nil
+}
+
+// CompleteJob завершает джобу и сохраняет её результат отдельным ключом, который живёт не дольше result_ttl
+func (r *RedisRepository) CompleteJob(ctx context.Context, jobID string, result []byte) error {
+  if r.cfg.MaxResultSize > 0 && int64(len(result)) > r.cfg.MaxResultSize {
+    wrapped := errors.Wrapf(service.ErrResultTooLarge, "%d bytes", len(result))
+    log.Error().Err(wrapped).Str("job_id", jobID).Msg(wrapped.Error())
+    return wrapped
+  }
+
+  keys := []string{taskKey(jobID), r.inflightName(), resultKey(jobID), r.queueName, r.signalName()}
+  err := completeScript.Run(ctx, r.client, keys, jobID, time.Now().Format(time.RFC3339), result,
+    r.cfg.ResultTTL.Milliseconds(), TaskPrefix, DependentsSuffix).Err()
+  if err != nil {
+    wrapped := errors.Wrap(scriptError(err), errs.ErrUpdateJob)
+    log.Error().Err(wrapped).Msg(wrapped.Error())
+    return wrapped
+  }
+
+  return nil
+}
+
+// GetJobResult возвращает результат завершённой джобы. для остальных статусов возвращается ошибка, объясняющая,
+// почему результата нет
+func (r *RedisRepository) GetJobResult(ctx context.Context, jobID string) ([]byte, error) {
+  pipe := r.client.Pipeline()
+  statusCmd := pipe.HGet(ctx, taskKey(jobID), "status")
+  resultCmd := pipe.Get(ctx, resultKey(jobID))
+  _, err := pipe.Exec(ctx)
+  if err != nil && !errors.Is(err, redis.Nil) {
+    wrapped := errors.Wrap(err, errs.ErrGetJobResult)
+    log.Error().Err(wrapped).Msg(wrapped.Error())
+    return nil, wrapped
+  }
+
+  status := statusCmd.Val()
+  switch status {
+  case "":
+    return nil, service.ErrJobNotFound
+  case StatusCompleted:
+  case StatusFailed, StatusCancelled, StatusExpired:
+    return nil, errors.Wrapf(service.ErrNoResult, "job %s", status)
+  default:
+    return nil, errors.Wrapf(service.ErrResultNotReady, "job %s", status)
+  }
+
+  result, err := resultCmd.Bytes()
+  if errors.Is(err, redis.Nil) {
+    return nil, service.ErrResultNotFound
+  }
   if err != nil {
-    wrapped := errors.Wrap(err, errs.ErrUpdateJob)
+    wrapped := errors.Wrap(err, errs.ErrGetJobResult)
     log.Error().Err(wrapped).Msg(wrapped.Error())
     return nil, wrapped
   }
 
-  return job, nil
+  return result, nil
 }
 
-func (r *RedisRepository) CompleteJob(ctx context.Context, jobID string) error {
-  return r.client.HSet(ctx, fmt.Sprintf("task:%s", jobID), map[string]interface{}{
-    "status":      StatusCompleted,
-    "finished_at": time.Now().Format(time.RFC3339),
-  }).Err()
+// FailJob окончательно роняет джобу и переносит её в dead letter queue вместе с записью о последней попытке
+func (r *RedisRepository) FailJob(ctx context.Context, jobID string, record models.AttemptRecord) error {
+  data, err := json.Marshal(record)
+  if err != nil {
+    wrapped := errors.Wrap(err, errs.ErrMarshalJob)
+    log.Error().Err(wrapped).Msg(wrapped.Error())
+    return wrapped
+  }
+
+  err = failScript.Run(ctx, r.client, []string{taskKey(jobID), r.inflightName(), r.deadName(), attemptsKey(jobID)},
+    jobID, record.FailedAt.Format(time.RFC3339), record.FailedAt.UnixMilli(), data, record.Error,
+    TaskPrefix, DependentsSuffix).Err()
+  if err != nil {
+    wrapped := errors.Wrap(scriptError(err), errs.ErrUpdateJob)
+    log.Error().Err(wrapped).Msg(wrapped.Error())
+    return wrapped
+  }
+
+  return nil
 }
 
-func (r *RedisRepository) FailJob(ctx context.Context, jobID string) error {
-  return r.client.HSet(ctx, fmt.Sprintf("task:%s", jobID), map[string]interface{}{
-    "status":      StatusFailed,
-    "finished_at": time.Now().Format(time.RFC3339),
-  }).Err()
+func (r *RedisRepository) RetryJob(ctx context.Context, job *models.Job, runAt time.Time, record models.AttemptRecord) error {
+  data, err := json.Marshal(record)
+  if err != nil {
+    wrapped := errors.Wrap(err, errs.ErrMarshalJob)
+    log.Error().Err(wrapped).Msg(wrapped.Error())
+    return wrapped
+  }
+
+  // в отложенной очереди score - время, когда джобу можно вернуть в основную очередь
+  err = retryScript.Run(ctx, r.client, []string{taskKey(job.ID), r.inflightName(), r.delayedName(), attemptsKey(job.ID)},
+    job.ID, runAt.UnixMilli(), runAt.Format(time.RFC3339), data, record.Error).Err()
+  if err != nil {
+    wrapped := errors.Wrap(scriptError(err), errs.ErrUpdateJob)
+    log.Error().Err(wrapped).Msg(wrapped.Error())
+    return wrapped
+  }
+
+  return nil
+}
+
+func (r *RedisRepository) PromoteJobs(ctx context.Context, now time.Time) (int, error) {
+  promoted, err := promoteScript.Run(ctx, r.client, []string{r.delayedName(), r.queueName, r.signalName()},
+    TaskPrefix, now.UnixMilli(), PromoteBatchSize).Int()
+  if err != nil {
+    wrapped := errors.Wrap(err, errs.ErrUpdateJob)
+    log.Error().Err(wrapped).Msg(wrapped.Error())
+    return 0, wrapped
+  }
+
+  return promoted, nil
+}
+
+// InterruptJob возвращает выполняющуюся джобу в очередь при остановке пула, прерванная попытка не учитывается
+func (r *RedisRepository) InterruptJob(ctx context.Context, jobID string) error {
+  err := interruptScript.Run(ctx, r.client, []string{taskKey(jobID), r.inflightName(), r.queueName, r.signalName()},
+    jobID, time.Now().Format(time.RFC3339)).Err()
+  if err != nil {
+    wrapped := errors.Wrap(scriptError(err), errs.ErrUpdateJob)
+    log.Error().Err(wrapped).Msg(wrapped.Error())
+    return wrapped
+  }
+
+  return nil
+}
+
+// CancelJob отменяет джобу и возвращает статус, в котором она была. выполняющуюся джобу инстанс, который её держит,
+// отменит по сообщению из канала отмен
+func (r *RedisRepository) CancelJob(ctx context.Context, jobID, reason string) (string, error) {
+  now := time.Now()
+  keys := []string{taskKey(jobID), r.queueName, r.delayedName(), r.deadName(), r.inflightName()}
+  previous, err := cancelScript.Run(ctx, r.client, keys, jobID, now.Format(time.RFC3339), reason, TaskPrefix,
+    DependentsSuffix, now.UnixMilli(), r.cancelChannel()).Text()
+  if err != nil {
+    wrapped := errors.Wrap(scriptError(err), errs.ErrCancelJob)
+    log.Error().Err(wrapped).Msg(wrapped.Error())
+    return "", wrapped
+  }
+
+  return previous, nil
+}
+
+// WatchCancellations вызывает handle для каждой выполняющейся джобы, отменённой через CancelJob на любом инстансе.
+// блокируется, пока не отменён ctx
+func (r *RedisRepository) WatchCancellations(ctx context.Context, handle func(jobID string)) error {
+  sub := r.client.Subscribe(ctx, r.cancelChannel())
+  defer func() {
+    if err := sub.Close(); err != nil {
+      wrapped := errors.Wrap(err, errs.ErrWatchCancellations)
+      log.Error().Err(wrapped).Msg(wrapped.Error())
+    }
+  }()
+
+  // ждём подтверждения подписки, дальше переподключения go-redis делает сам
+  if _, err := sub.Receive(ctx); err != nil {
+    wrapped := errors.Wrap(err, errs.ErrWatchCancellations)
+    log.Error().Err(wrapped).Msg(wrapped.Error())
+    return wrapped
+  }
+
+  messages := sub.Channel()
+  for {
+    select {
+    case <-ctx.Done():
+      return nil
+    case msg, ok := <-messages:
+      if !ok {
+        return nil
+      }
+      handle(msg.Payload)
+    }
+  }
 }
 
 func (r *RedisRepository) GetJobStatus(ctx context.Context, jobID string) (string, error) {
-  res, err := r.client.HGetAll(ctx, fmt.Sprintf("task:%s", jobID)).Result()
+  res, err := r.client.HGetAll(ctx, taskKey(jobID)).Result()
   if err != nil {
     wrapped := errors.Wrap(err, errs.ErrGetJobStatus)
     log.Error().Err(wrapped).Msg(wrapped.Error())
     return "", wrapped
   }
 
-  jsonResp, err := json.Marshal(res)
+  resp := make(map[string]interface{}, len(res))
+  for field, value := range res {
+    resp[field] = value
+  }
+  // json поля хранятся как строки, но отдавать их нужно в исходном виде
+  for _, field := range jsonFields {
+    if value, ok := res[field]; ok {
+      resp[field] = json.RawMessage(value)
+    }
+  }
+
+  jsonResp, err := json.Marshal(resp)
   if err != nil {
     wrapped := errors.Wrap(err, errs.ErrUnmarshalJobStatus)
     log.Error().Err(wrapped).Msg(wrapped.Error())
@@ -133,3 +438,131 @@ func (r *RedisRepository) GetJobStatus(ctx context.Context, jobID string) (strin
 
   return string(jsonResp), nil
 }
+
+// RecordPanic сохраняет в джобе последнюю панику обработчика, статус джобы при этом меняет ретрай или fail
+func (r *RedisRepository) RecordPanic(ctx context.Context, jobID, value, stack string) error {
+  err := r.client.HSet(ctx, taskKey(jobID), "panic", value, "panic_stack", stack,
+    "panicked_at", time.Now().Format(time.RFC3339)).Err()
+  if err != nil {
+    wrapped := errors.Wrap(err, errs.ErrUpdateJob)
+    log.Error().Err(wrapped).Msg(wrapped.Error())
+    return wrapped
+  }
+
+  return nil
+}
+
+func (r *RedisRepository) SetProgress(ctx context.Context, jobID string, delivery, percent int, message string,
+  at time.Time) error {
+  err := progressScript.Run(ctx, r.client, []string{taskKey(jobID)}, delivery, percent, message,
+    at.Format(time.RFC3339)).Err()
+  if err != nil {
+    wrapped := errors.Wrap(err, errs.ErrUpdateJob)
+    log.Error().Err(wrapped).Msg(wrapped.Error())
+    return wrapped
+  }
+
+  return nil
+}
+
+// QueueDepth возвращает количество джоб, готовых к выполнению. отложенные и выполняющиеся джобы не учитываются
+func (r *RedisRepository) QueueDepth(ctx context.Context) (int64, error) {
+  depth, err := r.client.ZCard(ctx, r.queueName).Result()
+  if err != nil {
+    wrapped := errors.Wrap(err, errs.ErrQueueDepth)
+    log.Error().Err(wrapped).Msg(wrapped.Error())
+    return 0, wrapped
+  }
+
+  return depth, nil
+}
+
+func (r *RedisRepository) delayedName() string {
+  return r.queueName + ":delayed"
+}
+
+// WaitForJob блокируется, пока в очереди не появится джоба или не истечёт timeout. false - джоб так и не появилось
+func (r *RedisRepository) WaitForJob(ctx context.Context, timeout time.Duration) (bool, error) {
+  err := r.client.BLPop(ctx, timeout, r.signalName()).Err()
+  if errors.Is(err, redis.Nil) {
+    return false, nil
+  }
+  // ожидание прервали при остановке, это не ошибка redis
+  if ctx.Err() != nil {
+    return false, ctx.Err()
+  }
+  if err != nil {
+    wrapped := errors.Wrap(err, errs.ErrWaitForJob)
+    log.Error().Err(wrapped).Msg(wrapped.Error())
+    return false, wrapped
+  }
+
+  return true, nil
+}
+
+func (r *RedisRepository) signalName() string {
+  return r.queueName + ":signal"
+}
+
+func (r *RedisRepository) uniqueLockName(uniqueKey string) string {
+  return r.queueName + ":unique:" + uniqueKey
+}
+
+func (r *RedisRepository) cancelChannel() string {
+  return r.queueName + ":cancel"
+}
+
+func (r *RedisRepository) inflightName() string {
+  return r.queueName + ":inflight"
+}
+
+func taskKey(jobID string) string {
+  return TaskPrefix + jobID
+}
+
+func resultKey(jobID string) string {
+  return TaskPrefix + jobID + ResultSuffix
+}
+
+func attemptsKey(jobID string) string {
+  return TaskPrefix + jobID + AttemptsSuffix
+}
+
+// scriptError превращает ошибку скрипта о запрещённом переходе в service.ErrIllegalTransition, о несуществующей
+// джобе или родителе - в service.ErrJobNotFound и service.ErrDependencyNotFound, а о дубликате уникальной
+// джобы - в service.DuplicateJobError
+func scriptError(err error) error {
+  if err.Error() == IllegalReplyPrefix+"missing" {
+    return service.ErrJobNotFound
+  }
+  if strings.HasPrefix(err.Error(), IllegalReplyPrefix) {
+    return errors.Wrapf(service.ErrIllegalTransition, "from %s", strings.TrimPrefix(err.Error(), IllegalReplyPrefix))
+  }
+  if strings.HasPrefix(err.Error(), DuplicateReplyPrefix) {
+    return &service.DuplicateJobError{ID: strings.TrimPrefix(err.Error(), DuplicateReplyPrefix)}
+  }
+  if strings.HasPrefix(err.Error(), MissingReplyPrefix) {
+    return errors.Wrap(service.ErrDependencyNotFound, strings.TrimPrefix(err.Error(), MissingReplyPrefix))
+  }
+
+  return err
+}
+
+func hashFromReply(reply interface{}) (map[string]string, error) {
+  values, ok := reply.([]interface{})
+  if !ok || len(values)%2 != 0 {
+    return nil, errors.New(errs.ErrCastError)
+  }
+
+  fields := make(map[string]string, len(values)/2)
+  for i := 0; i < len(values); i += 2 {
+    field, okField := values[i].(string)
+    value, okValue := values[i+1].(string)
+    if !okField || !okValue {
+      return nil, errors.New(errs.ErrCastError)
+    }
+    fields[field] = value
+  }
+
+  return fields, nil
+}
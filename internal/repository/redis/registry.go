@@ -0,0 +1,116 @@
+package repository
+
+import (
+  "context"
+  "encoding/json"
+  "strconv"
+  "time"
+
+  errs "flussonic_tz/internal/errors"
+  "flussonic_tz/models"
+
+  "github.com/go-redis/redis/v8"
+  "github.com/pkg/errors"
+  "github.com/rs/zerolog/log"
+)
+
+// RegisterInstance сохраняет состояние инстанса с ttl. в {queue}:instances хранится id инстанса со временем, когда
+// запись истечёт, чтобы список живых инстансов не требовал SCAN по ключам
+func (r *RedisRepository) RegisterInstance(ctx context.Context, instance *models.Instance, ttl time.Duration) error {
+  data, err := json.Marshal(instance)
+  if err != nil {
+    wrapped := errors.Wrap(err, errs.ErrMarshalInstance)
+    log.Error().Err(wrapped).Msg(wrapped.Error())
+    return wrapped
+  }
+
+  _, err = r.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+    pipe.Set(ctx, r.instanceKey(instance.ID), data, ttl)
+    pipe.ZAdd(ctx, r.instancesName(), &redis.Z{
+      Score:  float64(instance.HeartbeatAt.Add(ttl).UnixMilli()),
+      Member: instance.ID,
+    })
+    return nil
+  })
+  if err != nil {
+    wrapped := errors.Wrap(err, errs.ErrRegisterInstance)
+    log.Error().Err(wrapped).Msg(wrapped.Error())
+    return wrapped
+  }
+
+  return nil
+}
+
+func (r *RedisRepository) UnregisterInstance(ctx context.Context, instanceID string) error {
+  _, err := r.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+    pipe.Del(ctx, r.instanceKey(instanceID))
+    pipe.ZRem(ctx, r.instancesName(), instanceID)
+    return nil
+  })
+  if err != nil {
+    wrapped := errors.Wrap(err, errs.ErrUnregisterInstance)
+    log.Error().Err(wrapped).Msg(wrapped.Error())
+    return wrapped
+  }
+
+  return nil
+}
+
+// ListInstances возвращает инстансы, которые обновляли запись в пределах ttl, заодно вычищая истёкшие
+func (r *RedisRepository) ListInstances(ctx context.Context, now time.Time) ([]models.Instance, error) {
+  err := r.client.ZRemRangeByScore(ctx, r.instancesName(), "-inf", strconv.FormatInt(now.UnixMilli(), 10)).Err()
+  if err != nil {
+    wrapped := errors.Wrap(err, errs.ErrListInstances)
+    log.Error().Err(wrapped).Msg(wrapped.Error())
+    return nil, wrapped
+  }
+
+  ids, err := r.client.ZRange(ctx, r.instancesName(), 0, -1).Result()
+  if err != nil {
+    wrapped := errors.Wrap(err, errs.ErrListInstances)
+    log.Error().Err(wrapped).Msg(wrapped.Error())
+    return nil, wrapped
+  }
+
+  instances := make([]models.Instance, 0, len(ids))
+  if len(ids) == 0 {
+    return instances, nil
+  }
+
+  keys := make([]string, 0, len(ids))
+  for _, id := range ids {
+    keys = append(keys, r.instanceKey(id))
+  }
+  values, err := r.client.MGet(ctx, keys...).Result()
+  if err != nil {
+    wrapped := errors.Wrap(err, errs.ErrListInstances)
+    log.Error().Err(wrapped).Msg(wrapped.Error())
+    return nil, wrapped
+  }
+
+  for _, value := range values {
+    // ключ мог истечь между ZRANGE и MGET
+    data, ok := value.(string)
+    if !ok {
+      continue
+    }
+
+    var instance models.Instance
+    if err = json.Unmarshal([]byte(data), &instance); err != nil {
+      wrapped := errors.Wrap(err, errs.ErrListInstances)
+      log.Error().Err(wrapped).Msg(wrapped.Error())
+      return nil, wrapped
+    }
+    instances = append(instances, instance)
+  }
+
+  return instances, nil
+}
+
+func (r *RedisRepository) instancesName() string {
+  return r.queueName + ":instances"
+}
+
+func (r *RedisRepository) instanceKey(instanceID string) string {
+  return r.queueName + ":instance:" + instanceID
+}
@@ -0,0 +1,586 @@
+package repository
+
+import (
+  "github.com/go-redis/redis/v8"
+)
+
+// все переходы между статусами выполняются на стороне redis, чтобы очередь и хэш джобы не могли разойтись.
+// скрипт проверяет текущий статус и возвращает ошибку ILLEGAL <status>, если переход из него запрещён.
+// ключи task:{id} для пачек джоб собираются внутри скрипта из префикса, поэтому скрипты рассчитаны на
+// standalone redis, а не на cluster
+
+// notifyLua будит воркеров, ждущих джоб в BLPOP: на каждую попавшую в очередь джобу кладётся сигнал в список,
+// который всегда передаётся последним ключом. список обрезается, чтобы при простое воркеров он не рос бесконечно
+const notifyLua = `
+local function notify(count)
+  for _ = 1, count do
+    redis.call('LPUSH', KEYS[#KEYS], 1)
+  end
+  redis.call('LTRIM', KEYS[#KEYS], 0, 1023)
+end
+`
+
+// batchLua ведёт счётчики пачки batch:{id}, в которую входит джоба (поле batch_id). finish_batch вызывается при
+// любом окончательном завершении джобы, и когда pending доходит до нуля, ждущая callback джоба пачки попадает в
+// очередь. ключ сигнала строится из имени очереди так же, как в RedisRepository.signalName.
+// reopen_batch откатывает счётчики, когда упавшую джобу пачки перезапускают из dead letter queue
+const batchLua = `
+local function finish_batch(key, counter)
+  local batch_id = redis.call('HGET', key, 'batch_id')
+  if not batch_id then
+    return
+  end
+  local batch = '` + BatchPrefix + `' .. batch_id
+  redis.call('HINCRBY', batch, counter, 1)
+  if redis.call('HINCRBY', batch, 'pending', -1) > 0 then
+    return
+  end
+  local callback = redis.call('HGET', batch, 'callback_id')
+  if not callback then
+    return
+  end
+  local callback_key = '` + TaskPrefix + `' .. callback
+  if redis.call('HGET', callback_key, 'status') == 'waiting' then
+    local queue = redis.call('HGET', batch, 'queue')
+    redis.call('HSET', callback_key, 'status', 'pending')
+    redis.call('ZADD', queue, redis.call('HGET', callback_key, 'score'), callback)
+    redis.call('LPUSH', queue .. ':signal', 1)
+    redis.call('LTRIM', queue .. ':signal', 0, 1023)
+  end
+end
+
+local function reopen_batch(key)
+  local batch_id = redis.call('HGET', key, 'batch_id')
+  if batch_id then
+    redis.call('HINCRBY', '` + BatchPrefix + `' .. batch_id, 'failed', -1)
+    redis.call('HINCRBY', '` + BatchPrefix + `' .. batch_id, 'pending', 1)
+  end
+end
+`
+
+// uniqueLua следит за уникальностью джоб с unique_key. ключ блокировки (поле unique_lock) хранит id джобы-владельца.
+// блокировка со scope ttl просто живёт свой ttl, остальные снимаются release_unique, когда джоба завершилась, а
+// со scope pending - уже когда её взяли в работу. если снять блокировку не удалось (например, джобу удалили из dead
+// letter queue), claim_unique сам проверяет статус владельца и перехватывает устаревшую блокировку
+const uniqueLua = `
+local function unique_held(scope, status)
+  if not status then
+    return false
+  end
+  if scope == 'pending' then
+    return status == 'pending' or status == 'scheduled' or status == 'waiting'
+  end
+  return status ~= 'completed' and status ~= 'failed' and status ~= 'cancelled' and status ~= 'expired'
+end
+
+-- возвращает id другой джобы, которая держит блокировку, или nil, если блокировка досталась джобе id
+local function lock_unique(lock, id, scope, ttl)
+  local owner = redis.call('GET', lock)
+  if owner and owner ~= id then
+    local owner_key = '` + TaskPrefix + `' .. owner
+    if redis.call('PTTL', lock) > 0 or
+      unique_held(redis.call('HGET', owner_key, 'unique_scope'), redis.call('HGET', owner_key, 'status')) then
+      return owner
+    end
+  end
+  if scope == 'ttl' then
+    redis.call('SET', lock, id, 'PX', ttl)
+  else
+    redis.call('SET', lock, id)
+  end
+  return nil
+end
+
+-- блокировка новой джобы, поля которой переданы парами в ARGV начиная с from
+local function claim_unique(id, from)
+  local fields = {}
+  for i = from, #ARGV, 2 do
+    fields[ARGV[i]] = ARGV[i + 1]
+  end
+  if not fields['unique_lock'] then
+    return nil
+  end
+  return lock_unique(fields['unique_lock'], id, fields['unique_scope'], fields['unique_ttl'])
+end
+
+-- повторная блокировка уже сохранённой джобы, например при перезапуске из dead letter queue
+local function relock_unique(key, id)
+  local fields = redis.call('HMGET', key, 'unique_lock', 'unique_scope', 'unique_ttl')
+  if not fields[1] then
+    return nil
+  end
+  return lock_unique(fields[1], id, fields[2], fields[3])
+end
+
+local function release_unique(key, id)
+  local lock = redis.call('HGET', key, 'unique_lock')
+  if lock and redis.call('PTTL', lock) == -1 and redis.call('GET', lock) == id then
+    redis.call('DEL', lock)
+  end
+end
+`
+
+// dependentsLua разбирает ребра графа зависимостей, которые хранятся у родителя в хэше task:{id}:dependents
+// (id зависимой джобы -> политика). счётчик pending_deps у зависимой джобы - сколько родителей ещё не завершилось.
+// release_dependents вызывается при успешном завершении родителя и переводит в очередь джобы, у которых счётчик
+// дошёл до нуля. abort_dependents вызывается, когда родитель упал или отменён: зависимые джобы отменяются или
+// падают по политике ребра, а вслед за ними и их собственные зависимые
+const dependentsLua = `
+local function release_dependents(prefix, suffix, id, queue)
+  local edges_key = prefix .. id .. suffix
+  local children = redis.call('HKEYS', edges_key)
+  redis.call('DEL', edges_key)
+  local released = 0
+  for _, child in ipairs(children) do
+    local key = prefix .. child
+    if redis.call('HGET', key, 'status') == 'waiting' and redis.call('HINCRBY', key, 'pending_deps', -1) <= 0 then
+      redis.call('HSET', key, 'status', 'pending')
+      redis.call('HDEL', key, 'pending_deps')
+      redis.call('ZADD', queue, redis.call('HGET', key, 'score'), child)
+      released = released + 1
+    end
+  end
+  return released
+end
+
+local function abort_job(key, id, parent, policy, reason, dead, finished_at, now_ms)
+  redis.call('HDEL', key, 'pending_deps')
+  -- по этому полю перезапуск из dead letter queue узнаёт, что джоба не запускалась из-за родителя
+  redis.call('HSET', key, 'aborted_by', parent)
+  if policy == 'fail' then
+    redis.call('HSET', key, 'status', 'failed', 'finished_at', finished_at, 'error', reason)
+    redis.call('ZADD', dead, now_ms, id)
+    finish_batch(key, 'failed')
+  else
+    redis.call('HSET', key, 'status', 'cancelled', 'finished_at', finished_at, 'cancel_reason', reason)
+    finish_batch(key, 'cancelled')
+  end
+  release_unique(key, id)
+end
+
+local function abort_dependents(prefix, suffix, id, dead, finished_at, now_ms)
+  local parents = {id}
+  local aborted = 0
+  while #parents > 0 do
+    local parent = table.remove(parents)
+    local edges_key = prefix .. parent .. suffix
+    local edges = redis.call('HGETALL', edges_key)
+    redis.call('DEL', edges_key)
+    local reason = 'dependency ' .. parent .. ' ' .. (redis.call('HGET', prefix .. parent, 'status') or 'missing')
+    for i = 1, #edges, 2 do
+      local key = prefix .. edges[i]
+      if redis.call('HGET', key, 'status') == 'waiting' then
+        abort_job(key, edges[i], parent, edges[i + 1], reason, dead, finished_at, now_ms)
+        table.insert(parents, edges[i])
+        aborted = aborted + 1
+      end
+    end
+  end
+  return aborted
+end
+`
+
+// expireLua завершает джобу, которую не успели взять в работу до её expires_at. дедлайны лежат в zset
+// {queue}:expiring (score - expires_at в ms), оттуда джобу убирают, как только она начала выполняться.
+// для зависимых джоб просроченный родитель - то же, что упавший
+const expireLua = `
+local function expire_job(prefix, suffix, key, id, dead, finished_at, now_ms)
+  redis.call('HSET', key, 'status', 'expired', 'finished_at', finished_at)
+  redis.call('HDEL', key, 'pending_deps')
+  finish_batch(key, 'expired')
+  release_unique(key, id)
+  abort_dependents(prefix, suffix, id, dead, finished_at, now_ms)
+end
+`
+
+// KEYS: task, queue, expiring, signal. ARGV: id, score, expires_at ms (0 - без срока), пары поле-значение для хэша.
+// дубликат уникальной джобы не добавляется, скрипт возвращает ошибку DUPLICATE <id существующей джобы>
+var enqueueScript = redis.NewScript(notifyLua + uniqueLua + `
+if redis.call('EXISTS', KEYS[1]) == 1 then
+  return redis.error_reply('ILLEGAL ' .. (redis.call('HGET', KEYS[1], 'status') or 'exists'))
+end
+local owner = claim_unique(ARGV[1], 4)
+if owner then
+  return redis.error_reply('DUPLICATE ' .. owner)
+end
+redis.call('HSET', KEYS[1], unpack(ARGV, 4))
+redis.call('ZADD', KEYS[2], ARGV[2], ARGV[1])
+if tonumber(ARGV[3]) > 0 then
+  redis.call('ZADD', KEYS[3], ARGV[3], ARGV[1])
+end
+notify(1)
+return 1
+`)
+
+// KEYS: task, queue, dead, expiring, signal. ARGV: task prefix, суффикс рёбер, id, score, finished_at, now ms,
+// expires_at ms (0 - без срока), количество зависимостей, пары id-политика, пары поле-значение для хэша.
+// завершившиеся родители не учитываются, на остальных джоба подписывается и ждёт в статусе waiting. если кто-то из
+// родителей уже упал или отменён, политика его ребра применяется сразу
+var enqueueDependentScript = redis.NewScript(notifyLua + batchLua + uniqueLua + dependentsLua + `
+if redis.call('EXISTS', KEYS[1]) == 1 then
+  return redis.error_reply('ILLEGAL ' .. (redis.call('HGET', KEYS[1], 'status') or 'exists'))
+end
+local count = tonumber(ARGV[8])
+local waiting, aborted = {}, nil
+for i = 9, 8 + count * 2, 2 do
+  local parent, policy = ARGV[i], ARGV[i + 1]
+  local status = redis.call('HGET', ARGV[1] .. parent, 'status')
+  if not status then
+    return redis.error_reply('MISSING ' .. parent)
+  end
+  if status == 'failed' or status == 'cancelled' or status == 'expired' then
+    aborted = aborted or {parent, policy, status}
+  elseif status ~= 'completed' then
+    table.insert(waiting, {parent, policy})
+  end
+end
+local owner = claim_unique(ARGV[3], 9 + count * 2)
+if owner then
+  return redis.error_reply('DUPLICATE ' .. owner)
+end
+redis.call('HSET', KEYS[1], unpack(ARGV, 9 + count * 2))
+if aborted then
+  abort_job(KEYS[1], ARGV[3], aborted[1], aborted[2], 'dependency ' .. aborted[1] .. ' ' .. aborted[3], KEYS[3], ARGV[5],
+    ARGV[6])
+  return 'aborted'
+end
+if tonumber(ARGV[7]) > 0 then
+  redis.call('ZADD', KEYS[4], ARGV[7], ARGV[3])
+end
+if #waiting == 0 then
+  redis.call('ZADD', KEYS[2], ARGV[4], ARGV[3])
+  notify(1)
+  return 'pending'
+end
+for _, edge in ipairs(waiting) do
+  redis.call('HSET', ARGV[1] .. edge[1] .. ARGV[2], ARGV[3], edge[2])
+end
+redis.call('HSET', KEYS[1], 'status', 'waiting', 'pending_deps', #waiting)
+return 'waiting'
+`)
+
+// KEYS: task, delayed, expiring. ARGV: id, run_at ms, expires_at ms (0 - без срока), пары поле-значение для хэша
+var scheduleScript = redis.NewScript(uniqueLua + `
+if redis.call('EXISTS', KEYS[1]) == 1 then
+  return redis.error_reply('ILLEGAL ' .. (redis.call('HGET', KEYS[1], 'status') or 'exists'))
+end
+local owner = claim_unique(ARGV[1], 4)
+if owner then
+  return redis.error_reply('DUPLICATE ' .. owner)
+end
+redis.call('HSET', KEYS[1], unpack(ARGV, 4))
+redis.call('ZADD', KEYS[2], ARGV[2], ARGV[1])
+if tonumber(ARGV[3]) > 0 then
+  redis.call('ZADD', KEYS[3], ARGV[3], ARGV[1])
+end
+return 1
+`)
+
+// KEYS: queue, inflight, expiring, dead, signal. ARGV: task prefix, lease deadline ms, started_at, now ms, суффикс рёбер
+// возвращает id и поля хэша джобы или nil, если очередь пуста. просроченные джобы не выдаются, а сразу
+// завершаются со статусом expired. при пустой очереди оставшиеся сигналы уже ни к чему не относятся, они
+// удаляются, иначе простаивающие воркеры просыпались бы по ним вхолостую
+var dequeueScript = redis.NewScript(batchLua + uniqueLua + dependentsLua + expireLua + `
+while true do
+  local popped = redis.call('ZPOPMIN', KEYS[1])
+  if #popped == 0 then
+    redis.call('DEL', KEYS[5])
+    return false
+  end
+  local id = popped[1]
+  local key = ARGV[1] .. id
+  local status = redis.call('HGET', key, 'status')
+  local expires = redis.call('ZSCORE', KEYS[3], id)
+  if expires and (status == 'pending' or status == 'interrupted') then
+    -- начавшая выполняться джоба больше не истекает, в том числе и на ретраях
+    redis.call('ZREM', KEYS[3], id)
+    if tonumber(expires) <= tonumber(ARGV[4]) then
+      expire_job(ARGV[1], ARGV[5], key, id, KEYS[4], ARGV[3], ARGV[4])
+      status = 'expired'
+    end
+  end
+  if status == 'pending' or status == 'interrupted' then
+    redis.call('ZADD', KEYS[2], ARGV[2], id)
+    redis.call('HSET', key, 'status', 'in_progress', 'started_at', ARGV[3])
+    redis.call('HINCRBY', key, 'attempt', 1)
+    -- номер выдачи растёт при каждом взятии джобы, в отличие от attempt, который откатывается при возврате в очередь
+    redis.call('HINCRBY', key, 'delivery', 1)
+    -- прогресс относится к прошлой попытке
+    redis.call('HDEL', key, 'progress', 'progress_message', 'progress_updated_at')
+    if redis.call('HGET', key, 'unique_scope') == 'pending' then
+      release_unique(key, id)
+    end
+    return {id, redis.call('HGETALL', key)}
+  end
+  -- в очереди оказался id джобы, которая уже не ждёт выполнения, просто выбрасываем его
+end
+`)
+
+// KEYS: task, inflight, dead, attempts. ARGV: id, finished_at, dead_at ms, запись о попытке, error, task prefix,
+// суффикс рёбер. упавшая окончательно джоба попадает в dead letter queue, где её можно посмотреть и перезапустить
+var failScript = redis.NewScript(batchLua + uniqueLua + dependentsLua + `
+local status = redis.call('HGET', KEYS[1], 'status')
+if status ~= 'in_progress' then
+  return redis.error_reply('ILLEGAL ' .. (status or 'missing'))
+end
+redis.call('ZREM', KEYS[2], ARGV[1])
+redis.call('HSET', KEYS[1], 'status', 'failed', 'finished_at', ARGV[2], 'error', ARGV[5])
+redis.call('RPUSH', KEYS[4], ARGV[4])
+redis.call('ZADD', KEYS[3], ARGV[3], ARGV[1])
+finish_batch(KEYS[1], 'failed')
+release_unique(KEYS[1], ARGV[1])
+abort_dependents(ARGV[6], ARGV[7], ARGV[1], KEYS[3], ARGV[2], ARGV[3])
+return 1
+`)
+
+// KEYS: task, inflight, result, queue, signal. ARGV: id, finished_at, результат, ttl результата в ms (0 - без ttl),
+// task prefix, суффикс рёбер
+var completeScript = redis.NewScript(notifyLua + batchLua + uniqueLua + dependentsLua + `
+local status = redis.call('HGET', KEYS[1], 'status')
+if status ~= 'in_progress' then
+  return redis.error_reply('ILLEGAL ' .. (status or 'missing'))
+end
+redis.call('ZREM', KEYS[2], ARGV[1])
+redis.call('HSET', KEYS[1], 'status', 'completed', 'finished_at', ARGV[2])
+if ARGV[3] ~= '' then
+  if tonumber(ARGV[4]) > 0 then
+    redis.call('SET', KEYS[3], ARGV[3], 'PX', ARGV[4])
+  else
+    redis.call('SET', KEYS[3], ARGV[3])
+  end
+end
+finish_batch(KEYS[1], 'succeeded')
+release_unique(KEYS[1], ARGV[1])
+notify(release_dependents(ARGV[5], ARGV[6], ARGV[1], KEYS[4]))
+return 1
+`)
+
+// KEYS: task. ARGV: номер выдачи, progress, progress_message, progress_updated_at
+// прогресс пишется только пока джоба выполняется и только той выдачей, которая сейчас владеет джобой, чтобы
+// отставший обработчик (например, потерявший lease) не затёр прогресс следующей попытки
+var progressScript = redis.NewScript(`
+if redis.call('HGET', KEYS[1], 'status') ~= 'in_progress' or redis.call('HGET', KEYS[1], 'delivery') ~= ARGV[1] then
+  return 0
+end
+redis.call('HSET', KEYS[1], 'progress', ARGV[2], 'progress_message', ARGV[3], 'progress_updated_at', ARGV[4])
+return 1
+`)
+
+// KEYS: task, inflight, delayed, attempts. ARGV: id, run_at ms, next_retry_at, запись о попытке, error
+var retryScript = redis.NewScript(`
+local status = redis.call('HGET', KEYS[1], 'status')
+if status ~= 'in_progress' then
+  return redis.error_reply('ILLEGAL ' .. (status or 'missing'))
+end
+redis.call('ZREM', KEYS[2], ARGV[1])
+redis.call('ZADD', KEYS[3], ARGV[2], ARGV[1])
+redis.call('HSET', KEYS[1], 'status', 'retrying', 'next_retry_at', ARGV[3], 'error', ARGV[5])
+redis.call('RPUSH', KEYS[4], ARGV[4])
+return 1
+`)
+
+// KEYS: task, inflight, queue, signal. ARGV: id, interrupted_at
+var interruptScript = redis.NewScript(notifyLua + `
+local status = redis.call('HGET', KEYS[1], 'status')
+if status ~= 'in_progress' then
+  return redis.error_reply('ILLEGAL ' .. (status or 'missing'))
+end
+redis.call('ZREM', KEYS[2], ARGV[1])
+redis.call('HSET', KEYS[1], 'status', 'interrupted', 'interrupted_at', ARGV[2])
+redis.call('HINCRBY', KEYS[1], 'attempt', -1)
+redis.call('ZADD', KEYS[3], redis.call('HGET', KEYS[1], 'score'), ARGV[1])
+notify(1)
+return 1
+`)
+
+// KEYS: delayed, queue, signal. ARGV: task prefix, now ms, batch size
+var promoteScript = redis.NewScript(notifyLua + `
+local ids = redis.call('ZRANGEBYSCORE', KEYS[1], '-inf', ARGV[2], 'LIMIT', 0, ARGV[3])
+local promoted = 0
+for _, id in ipairs(ids) do
+  redis.call('ZREM', KEYS[1], id)
+  local key = ARGV[1] .. id
+  local status = redis.call('HGET', key, 'status')
+  if status == 'retrying' or status == 'scheduled' then
+    redis.call('HSET', key, 'status', 'pending')
+    redis.call('ZADD', KEYS[2], redis.call('HGET', key, 'score'), id)
+    promoted = promoted + 1
+  end
+end
+notify(promoted)
+return promoted
+`)
+
+// KEYS: expiring, queue, delayed, dead. ARGV: task prefix, now ms, batch size, finished_at, суффикс рёбер.
+// истекают только джобы, которые ещё не начинали выполняться, записи остальных (например, отменённых) просто
+// удаляются из expiring
+var expireScript = redis.NewScript(batchLua + uniqueLua + dependentsLua + expireLua + `
+local ids = redis.call('ZRANGEBYSCORE', KEYS[1], '-inf', ARGV[2], 'LIMIT', 0, ARGV[3])
+local expired = 0
+for _, id in ipairs(ids) do
+  redis.call('ZREM', KEYS[1], id)
+  local key = ARGV[1] .. id
+  local status = redis.call('HGET', key, 'status')
+  if status == 'pending' or status == 'interrupted' then
+    redis.call('ZREM', KEYS[2], id)
+  elseif status == 'scheduled' then
+    redis.call('ZREM', KEYS[3], id)
+  end
+  if status == 'pending' or status == 'interrupted' or status == 'scheduled' or status == 'waiting' then
+    expire_job(ARGV[1], ARGV[5], key, id, KEYS[4], ARGV[4], ARGV[2])
+    expired = expired + 1
+  end
+end
+return expired
+`)
+
+// KEYS: inflight, queue, dead, signal. ARGV: task prefix, now ms, batch size, max redeliveries по умолчанию,
+// finished_at, error, суффикс ключа истории попыток, суффикс рёбер
+var requeueExpiredScript = redis.NewScript(notifyLua + batchLua + uniqueLua + dependentsLua + `
+local ids = redis.call('ZRANGEBYSCORE', KEYS[1], '-inf', ARGV[2], 'LIMIT', 0, ARGV[3])
+local requeued, failed = 0, 0
+for _, id in ipairs(ids) do
+  redis.call('ZREM', KEYS[1], id)
+  local key = ARGV[1] .. id
+  local status = redis.call('HGET', key, 'status')
+  if status == 'in_progress' then
+    local limit = tonumber(redis.call('HGET', key, 'max_redeliveries') or ARGV[4])
+    local redeliveries = tonumber(redis.call('HGET', key, 'redeliveries') or '0')
+    if redeliveries >= limit then
+      redis.call('HSET', key, 'status', 'failed', 'finished_at', ARGV[5], 'error', ARGV[6])
+      redis.call('RPUSH', key .. ARGV[7], cjson.encode({
+        attempt = tonumber(redis.call('HGET', key, 'attempt') or '0'),
+        error = ARGV[6],
+        started_at = redis.call('HGET', key, 'started_at'),
+        failed_at = ARGV[5],
+      }))
+      redis.call('ZADD', KEYS[3], ARGV[2], id)
+      finish_batch(key, 'failed')
+      release_unique(key, id)
+      abort_dependents(ARGV[1], ARGV[8], id, KEYS[3], ARGV[5], ARGV[2])
+      failed = failed + 1
+    else
+      -- потерянная попытка, как и прерванная, не учитывается в ретраях: повторы доставки ограничивает
+      -- max_redeliveries
+      redis.call('HSET', key, 'status', 'pending', 'redeliveries', redeliveries + 1)
+      redis.call('HINCRBY', key, 'attempt', -1)
+      redis.call('ZADD', KEYS[2], redis.call('HGET', key, 'score'), id)
+      requeued = requeued + 1
+    end
+  end
+end
+notify(requeued)
+return {requeued, failed}
+`)
+
+// KEYS: task, queue, delayed, dead, inflight. ARGV: id, finished_at, reason, task prefix, суффикс рёбер, now ms,
+// канал отмен. ждущая родителей джоба ни в одной очереди не лежит, её рёбра у родителей остаются и просто
+// игнорируются. у выполняющейся джобы забирается lease и в канал публикуется её id, чтобы инстанс, который её
+// выполняет, отменил обработчик. если сообщение потеряется, инстанс заметит потерю lease при следующем продлении
+var cancelScript = redis.NewScript(batchLua + uniqueLua + dependentsLua + `
+local status = redis.call('HGET', KEYS[1], 'status')
+if status == 'pending' or status == 'interrupted' then
+  redis.call('ZREM', KEYS[2], ARGV[1])
+elseif status == 'retrying' or status == 'scheduled' then
+  redis.call('ZREM', KEYS[3], ARGV[1])
+elseif status == 'waiting' then
+  redis.call('HDEL', KEYS[1], 'pending_deps')
+elseif status == 'in_progress' then
+  redis.call('ZREM', KEYS[5], ARGV[1])
+  redis.call('PUBLISH', ARGV[7], ARGV[1])
+else
+  return redis.error_reply('ILLEGAL ' .. (status or 'missing'))
+end
+redis.call('HSET', KEYS[1], 'status', 'cancelled', 'finished_at', ARGV[2], 'cancel_reason', ARGV[3])
+finish_batch(KEYS[1], 'cancelled')
+release_unique(KEYS[1], ARGV[1])
+abort_dependents(ARGV[4], ARGV[5], ARGV[1], KEYS[4], ARGV[2], ARGV[6])
+return status
+`)
+
+// KEYS: dead, queue, signal. ARGV: task prefix, replayed_at, id...
+// перезапущенная джоба начинает попытки заново, история прошлых попыток сохраняется. возвращает число перезапущенных
+// джоб и пары id-причина для отклонённых, они остаются в dead letter queue. джоба, упавшая из-за родителя, ждёт, пока
+// все её родители не завершатся успешно, а джоба с unique_key - пока блокировку держит другая джоба
+var replayScript = redis.NewScript(notifyLua + batchLua + uniqueLua + `
+local function unfinished_parent(key)
+  if not redis.call('HGET', key, 'aborted_by') then
+    return nil
+  end
+  for _, dep in ipairs(cjson.decode(redis.call('HGET', key, 'depends_on') or '[]')) do
+    if redis.call('HGET', ARGV[1] .. dep['id'], 'status') ~= 'completed' then
+      return dep['id']
+    end
+  end
+  return nil
+end
+
+local replayed, rejected = 0, {}
+for i = 3, #ARGV do
+  local id = ARGV[i]
+  local key = ARGV[1] .. id
+  if redis.call('ZSCORE', KEYS[1], id) then
+    local reason = nil
+    if redis.call('HGET', key, 'status') == 'failed' then
+      local parent = unfinished_parent(key)
+      if parent then
+        reason = 'dependency ' .. parent .. ' is not completed'
+      else
+        local owner = relock_unique(key, id)
+        if owner then
+          reason = 'unique job ' .. owner .. ' is active'
+        end
+      end
+    end
+    if reason then
+      table.insert(rejected, id)
+      table.insert(rejected, reason)
+    else
+      redis.call('ZREM', KEYS[1], id)
+      if redis.call('HGET', key, 'status') == 'failed' then
+        redis.call('HSET', key, 'status', 'pending', 'attempt', 0, 'redeliveries', 0, 'replayed_at', ARGV[2])
+        redis.call('HDEL', key, 'finished_at', 'next_retry_at', 'aborted_by')
+        redis.call('HINCRBY', key, 'replays', 1)
+        reopen_batch(key)
+        redis.call('ZADD', KEYS[2], redis.call('HGET', key, 'score'), id)
+        replayed = replayed + 1
+      end
+    end
+  end
+end
+notify(replayed)
+return {replayed, rejected}
+`)
+
+// KEYS: dead. ARGV: task prefix, суффикс истории попыток, суффикс результата, id...
+var purgeScript = redis.NewScript(`
+local purged = 0
+for i = 4, #ARGV do
+  local id = ARGV[i]
+  if redis.call('ZREM', KEYS[1], id) == 1 then
+    local key = ARGV[1] .. id
+    redis.call('DEL', key, key .. ARGV[2], key .. ARGV[3])
+    purged = purged + 1
+  end
+end
+return purged
+`)
+
+// KEYS: cron tick. ARGV: tick ms
+var claimTickScript = redis.NewScript(`
+local last = tonumber(redis.call('GET', KEYS[1]) or '-1')
+if last >= tonumber(ARGV[1]) then
+  return 0
+end
+redis.call('SET', KEYS[1], ARGV[1])
+return 1
+`)
+
+// KEYS: cron tick. ARGV: tick ms, previous ms
+var releaseTickScript = redis.NewScript(`
+if redis.call('GET', KEYS[1]) ~= ARGV[1] then
+  return 0
+end
+redis.call('SET', KEYS[1], ARGV[2])
+return 1
+`)
@@ -0,0 +1,106 @@
+package repository
+
+import (
+  "context"
+  "encoding/json"
+  "time"
+
+  errs "flussonic_tz/internal/errors"
+  "flussonic_tz/internal/service"
+  "flussonic_tz/models"
+
+  "github.com/go-redis/redis/v8"
+  "github.com/pkg/errors"
+  "github.com/rs/zerolog/log"
+)
+
+const (
+  WorkflowPrefix = "workflow:"
+)
+
+// dependentJobScript собирает вызов скрипта для джобы с зависимостями. она сразу попадает в очередь, только если
+// все родители уже завершились, иначе ждёт их в статусе waiting
+func (r *RedisRepository) dependentJobScript(job *models.Job, status map[string]interface{}) (*jobScript, error) {
+  dependsOn, err := json.Marshal(job.DependsOn)
+  if err != nil {
+    wrapped := errors.Wrap(err, errs.ErrMarshalJob)
+    log.Error().Err(wrapped).Msg(wrapped.Error())
+    return nil, wrapped
+  }
+  status["depends_on"] = string(dependsOn)
+
+  now := time.Now()
+  args := []interface{}{TaskPrefix, DependentsSuffix, job.ID, job.Score, now.Format(time.RFC3339), now.UnixMilli(),
+    expiresAtMs(job), len(job.DependsOn)}
+  for _, dependency := range job.DependsOn {
+    args = append(args, dependency.ID, dependency.OnFailure)
+  }
+  for field, value := range status {
+    args = append(args, field, value)
+  }
+
+  keys := []string{taskKey(job.ID), r.queueName, r.deadName(), r.expiringName(), r.signalName()}
+  return &jobScript{script: enqueueDependentScript, keys: keys, args: args}, nil
+}
+
+// AddWorkflow одной транзакцией сохраняет состав workflow и добавляет его джобы, которые должны идти в
+// топологическом порядке. статусы джоб не хранятся, а читаются из их хэшей при запросе
+func (r *RedisRepository) AddWorkflow(ctx context.Context, workflow *models.Workflow, jobs []*models.Job) error {
+  data, err := json.Marshal(workflow)
+  if err != nil {
+    wrapped := errors.Wrap(err, errs.ErrSaveWorkflow)
+    log.Error().Err(wrapped).Msg(wrapped.Error())
+    return wrapped
+  }
+
+  err = r.addJobsTx(ctx, jobs, func(pipe redis.Pipeliner) {
+    pipe.Set(ctx, workflowKey(workflow.ID), data, 0)
+  })
+  if err != nil {
+    wrapped := errors.Wrap(err, errs.ErrSaveWorkflow)
+    log.Error().Err(wrapped).Msg(wrapped.Error())
+    return wrapped
+  }
+
+  return nil
+}
+
+// GetWorkflow возвращает workflow с текущими статусами его джоб. у удалённых из redis джоб статус пустой
+func (r *RedisRepository) GetWorkflow(ctx context.Context, workflowID string) (*models.Workflow, error) {
+  data, err := r.client.Get(ctx, workflowKey(workflowID)).Bytes()
+  if errors.Is(err, redis.Nil) {
+    return nil, service.ErrWorkflowNotFound
+  }
+  if err != nil {
+    wrapped := errors.Wrap(err, errs.ErrGetWorkflow)
+    log.Error().Err(wrapped).Msg(wrapped.Error())
+    return nil, wrapped
+  }
+
+  var workflow models.Workflow
+  if err = json.Unmarshal(data, &workflow); err != nil {
+    wrapped := errors.Wrap(err, errs.ErrGetWorkflow)
+    log.Error().Err(wrapped).Msg(wrapped.Error())
+    return nil, wrapped
+  }
+
+  pipe := r.client.Pipeline()
+  statusCmds := make([]*redis.StringCmd, 0, len(workflow.Jobs))
+  for _, job := range workflow.Jobs {
+    statusCmds = append(statusCmds, pipe.HGet(ctx, taskKey(job.ID), "status"))
+  }
+  if _, err = pipe.Exec(ctx); err != nil && !errors.Is(err, redis.Nil) {
+    wrapped := errors.Wrap(err, errs.ErrGetWorkflow)
+    log.Error().Err(wrapped).Msg(wrapped.Error())
+    return nil, wrapped
+  }
+  for i := range workflow.Jobs {
+    workflow.Jobs[i].Status = statusCmds[i].Val()
+  }
+
+  return &workflow, nil
+}
+
+func workflowKey(workflowID string) string {
+  return WorkflowPrefix + workflowID
+}
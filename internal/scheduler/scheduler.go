@@ -0,0 +1,280 @@
+package scheduler
+
+import (
+  "context"
+  "encoding/json"
+  "sync"
+  "time"
+
+  // в образе может не быть базы часовых поясов, а она нужна для timezone периодических джоб
+  _ "time/tzdata"
+
+  "flussonic_tz/config"
+  errs "flussonic_tz/internal/errors"
+  "flussonic_tz/models"
+
+  "github.com/pkg/errors"
+  "github.com/robfig/cron/v3"
+  "github.com/rs/zerolog/log"
+)
+
+const (
+  // сколько ближайших запусков показывать в /cron
+  UpcomingRuns = 5
+  // на сколько тик может опоздать при missed: skip, чтобы всё ещё считаться вовремя
+  MissedTickGrace = 1 * time.Minute
+)
+
+type JobService interface {
+  CreateJob(ctx context.Context, req *models.JobRequest) (string, error)
+}
+
+type Repository interface {
+  CronLastTick(ctx context.Context, name string) (time.Time, bool, error)
+  InitCronTick(ctx context.Context, name string, tick time.Time) error
+  ClaimCronTick(ctx context.Context, name string, tick time.Time) (bool, error)
+  ReleaseCronTick(ctx context.Context, name string, tick, previous time.Time) error
+}
+
+type entry struct {
+  cfg      config.CronJob
+  schedule cron.Schedule
+  location *time.Location
+  payload  json.RawMessage
+}
+
+// Scheduler ставит периодические джобы из секции cron. тики распределяются между репликами через redis,
+// поэтому каждый тик ставится один раз, сколько бы инстансов ни работало
+type Scheduler struct {
+  cfg     *config.Cron
+  jobSvc  JobService
+  repo    Repository
+  entries []*entry
+  wg      sync.WaitGroup
+  done    chan struct{}
+}
+
+func New(ctx context.Context, jobSvc JobService, repo Repository) (*Scheduler, error) {
+  cfg := config.FromCronContext(ctx)
+  if cfg.MaxCatchUp < 1 {
+    err := errors.Errorf("%s: max_catch_up=%d", errs.ErrInvalidCronConfig, cfg.MaxCatchUp)
+    log.Error().Err(err).Msg(err.Error())
+    return nil, err
+  }
+
+  entries := make([]*entry, 0, len(cfg.Jobs))
+  names := make(map[string]struct{}, len(cfg.Jobs))
+  for _, job := range cfg.Jobs {
+    e, err := newEntry(job)
+    if err != nil {
+      log.Error().Err(err).Msg(err.Error())
+      return nil, err
+    }
+    if _, ok := names[job.Name]; ok {
+      err = errors.Errorf("%s %s: duplicate name", errs.ErrInvalidCronJob, job.Name)
+      log.Error().Err(err).Msg(err.Error())
+      return nil, err
+    }
+    names[job.Name] = struct{}{}
+    entries = append(entries, e)
+  }
+
+  return &Scheduler{
+    cfg:     cfg,
+    jobSvc:  jobSvc,
+    repo:    repo,
+    entries: entries,
+    done:    make(chan struct{}),
+  }, nil
+}
+
+func newEntry(job config.CronJob) (*entry, error) {
+  if job.Name == "" {
+    return nil, errors.Errorf("%s: empty name", errs.ErrInvalidCronJob)
+  }
+
+  schedule, err := cron.ParseStandard(job.Schedule)
+  if err != nil {
+    return nil, errors.Wrapf(err, "%s %s", errs.ErrInvalidCronJob, job.Name)
+  }
+
+  location, err := time.LoadLocation(job.Timezone)
+  if err != nil {
+    return nil, errors.Wrapf(err, "%s %s", errs.ErrInvalidCronJob, job.Name)
+  }
+
+  switch job.Missed {
+  case "":
+    job.Missed = config.CronMissedSkip
+  case config.CronMissedSkip, config.CronMissedCatch:
+  default:
+    return nil, errors.Errorf("%s %s: unknown missed policy %s", errs.ErrInvalidCronJob, job.Name, job.Missed)
+  }
+
+  // payload задаётся строкой с JSON: ключи вложенной yaml map viper привёл бы к нижнему регистру
+  var payload json.RawMessage
+  if job.Payload != "" {
+    if !json.Valid([]byte(job.Payload)) {
+      return nil, errors.Errorf("%s %s: payload is not valid JSON", errs.ErrInvalidCronJob, job.Name)
+    }
+    payload = json.RawMessage(job.Payload)
+  }
+
+  return &entry{cfg: job, schedule: schedule, location: location, payload: payload}, nil
+}
+
+func (s *Scheduler) Start(ctx context.Context) {
+  if len(s.entries) == 0 {
+    return
+  }
+
+  s.wg.Add(1)
+  go s.run(ctx)
+  log.Info().Int("jobs", len(s.entries)).Msg("cron scheduler started")
+}
+
+func (s *Scheduler) Stop() {
+  close(s.done)
+  s.wg.Wait()
+}
+
+func (s *Scheduler) run(ctx context.Context) {
+  defer s.wg.Done()
+
+  ticker := time.NewTicker(s.cfg.PollInterval)
+  defer ticker.Stop()
+
+  for {
+    select {
+    case <-s.done:
+      return
+    case now := <-ticker.C:
+      for _, e := range s.entries {
+        s.tick(ctx, e, now)
+      }
+    }
+  }
+}
+
+// tick ставит джобы на все наступившие тики, которые ещё не забрал ни один инстанс
+func (s *Scheduler) tick(ctx context.Context, e *entry, now time.Time) {
+  last, ok, err := s.repo.CronLastTick(ctx, e.cfg.Name)
+  if err != nil {
+    wrapped := errors.Wrap(err, errs.ErrScheduleCronJob)
+    log.Error().Err(wrapped).Str("name", e.cfg.Name).Msg(wrapped.Error())
+    return
+  }
+  // новая джоба отсчитывает тики с момента первого запуска, а не с начала времён
+  if !ok {
+    if err = s.repo.InitCronTick(ctx, e.cfg.Name, now); err != nil {
+      wrapped := errors.Wrap(err, errs.ErrScheduleCronJob)
+      log.Error().Err(wrapped).Str("name", e.cfg.Name).Msg(wrapped.Error())
+    }
+    return
+  }
+
+  // при missed: skip тики старше MissedTickGrace не ставятся, поэтому курсор сразу переносится за них. если таких
+  // тиков нет, курсор не трогаем, чтобы не писать в redis на каждом опросе
+  skipFrom := now.Add(-MissedTickGrace)
+  if e.cfg.Missed == config.CronMissedSkip && !e.schedule.Next(last.In(e.location)).After(skipFrom) {
+    log.Info().Str("name", e.cfg.Name).Time("last_tick", last).Msg("cron ticks missed, skipping")
+    if _, err = s.repo.ClaimCronTick(ctx, e.cfg.Name, skipFrom); err != nil {
+      return
+    }
+    last = skipFrom
+  }
+
+  due, truncated := dueTicks(e.schedule, last.In(e.location), now, s.cfg.MaxCatchUp)
+  previous := last
+  for _, t := range due {
+    claimed, err := s.repo.ClaimCronTick(ctx, e.cfg.Name, t)
+    if err != nil {
+      return
+    }
+    if claimed {
+      if err = s.enqueue(ctx, e, t); err != nil {
+        // тик возвращается, чтобы его поставил следующий опрос. следующие тики до этого не забираются,
+        // иначе откатить курсор было бы уже нельзя
+        s.release(ctx, e, t, previous)
+        return
+      }
+    }
+    previous = t
+  }
+
+  // остальные пропущенные тики отбрасываются: курсор переносится на now, чтобы не догонять их бесконечно
+  if truncated {
+    claimed, err := s.repo.ClaimCronTick(ctx, e.cfg.Name, now)
+    if err == nil && claimed {
+      log.Warn().Str("name", e.cfg.Name).Time("dropped_after", previous).Msg("cron catch-up limit exceeded")
+    }
+  }
+}
+
+// dueTicks возвращает не больше limit наступивших тиков после from. true - тиков больше, чем limit
+func dueTicks(schedule cron.Schedule, from, now time.Time, limit int) ([]time.Time, bool) {
+  due := make([]time.Time, 0, limit)
+  for t := schedule.Next(from); !t.After(now); t = schedule.Next(t) {
+    if len(due) == limit {
+      return due, true
+    }
+    due = append(due, t)
+  }
+
+  return due, false
+}
+
+func (s *Scheduler) enqueue(ctx context.Context, e *entry, tick time.Time) error {
+  id, err := s.jobSvc.CreateJob(ctx, &models.JobRequest{
+    Name:    e.cfg.Name,
+    Score:   e.cfg.Score,
+    Payload: e.payload,
+  })
+  if err != nil {
+    wrapped := errors.Wrap(err, errs.ErrEnqueueCronJob)
+    log.Error().Err(wrapped).Str("name", e.cfg.Name).Msg(wrapped.Error())
+    return wrapped
+  }
+
+  log.Info().Str("name", e.cfg.Name).Str("job_id", id).Time("tick", tick).Msg("cron job enqueued")
+  return nil
+}
+
+func (s *Scheduler) release(ctx context.Context, e *entry, tick, previous time.Time) {
+  if err := s.repo.ReleaseCronTick(ctx, e.cfg.Name, tick, previous); err != nil {
+    wrapped := errors.Wrap(err, errs.ErrScheduleCronJob)
+    log.Error().Err(wrapped).Str("name", e.cfg.Name).Time("tick", tick).Msg(wrapped.Error())
+  }
+}
+
+// Entries возвращает периодические джобы с последним забранным тиком и ближайшими запусками
+func (s *Scheduler) Entries(ctx context.Context) ([]models.CronEntry, error) {
+  now := time.Now()
+  entries := make([]models.CronEntry, 0, len(s.entries))
+  for _, e := range s.entries {
+    item := models.CronEntry{
+      Name:     e.cfg.Name,
+      Schedule: e.cfg.Schedule,
+      Timezone: e.location.String(),
+      Score:    e.cfg.Score,
+      Missed:   e.cfg.Missed,
+      Upcoming: make([]time.Time, 0, UpcomingRuns),
+    }
+
+    last, ok, err := s.repo.CronLastTick(ctx, e.cfg.Name)
+    if err != nil {
+      return nil, err
+    }
+    if ok {
+      item.LastTick = &last
+    }
+
+    for t := now.In(e.location); len(item.Upcoming) < UpcomingRuns; {
+      t = e.schedule.Next(t)
+      item.Upcoming = append(item.Upcoming, t)
+    }
+    entries = append(entries, item)
+  }
+
+  return entries, nil
+}
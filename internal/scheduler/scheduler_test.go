@@ -0,0 +1,268 @@
+package scheduler
+
+import (
+  "context"
+  "errors"
+  "testing"
+  "time"
+
+  "flussonic_tz/config"
+  "flussonic_tz/models"
+
+  "github.com/robfig/cron/v3"
+)
+
+type fakeRepo struct {
+  ticks  map[string]time.Time
+  claims int
+}
+
+func (r *fakeRepo) CronLastTick(_ context.Context, name string) (time.Time, bool, error) {
+  tick, ok := r.ticks[name]
+  return tick, ok, nil
+}
+
+func (r *fakeRepo) InitCronTick(_ context.Context, name string, tick time.Time) error {
+  if _, ok := r.ticks[name]; !ok {
+    r.ticks[name] = tick
+  }
+  return nil
+}
+
+func (r *fakeRepo) ClaimCronTick(_ context.Context, name string, tick time.Time) (bool, error) {
+  r.claims++
+  if !tick.After(r.ticks[name]) {
+    return false, nil
+  }
+  r.ticks[name] = tick
+  return true, nil
+}
+
+func (r *fakeRepo) ReleaseCronTick(_ context.Context, name string, tick, previous time.Time) error {
+  if r.ticks[name].Equal(tick) {
+    r.ticks[name] = previous
+  }
+  return nil
+}
+
+type fakeJobService struct {
+  jobs   []models.JobRequest
+  failAt int
+}
+
+func (s *fakeJobService) CreateJob(_ context.Context, req *models.JobRequest) (string, error) {
+  if s.failAt > 0 && len(s.jobs)+1 == s.failAt {
+    s.failAt = 0
+    return "", errors.New("redis is down")
+  }
+  s.jobs = append(s.jobs, *req)
+  return "id", nil
+}
+
+func newTestScheduler(t *testing.T, maxCatchUp int, jobs ...config.CronJob) (*Scheduler, *fakeRepo, *fakeJobService) {
+  t.Helper()
+
+  repo := &fakeRepo{ticks: make(map[string]time.Time)}
+  svc := &fakeJobService{}
+  ctx := config.WrapCronContext(context.Background(), &config.Cron{MaxCatchUp: maxCatchUp, Jobs: jobs})
+  s, err := New(ctx, svc, repo)
+  if err != nil {
+    t.Fatal(err)
+  }
+  return s, repo, svc
+}
+
+func at(value string) time.Time {
+  t, err := time.Parse(time.RFC3339, value)
+  if err != nil {
+    panic(err)
+  }
+  return t
+}
+
+func TestDueTicks(t *testing.T) {
+  schedule, err := cron.ParseStandard("*/5 * * * *")
+  if err != nil {
+    t.Fatal(err)
+  }
+
+  tests := []struct {
+    name      string
+    from, now time.Time
+    limit     int
+    want      []time.Time
+    truncated bool
+  }{
+    {name: "nothing due", from: at("2025-03-19T12:00:00Z"), now: at("2025-03-19T12:04:59Z"), limit: 10},
+    {name: "tick at now is due", from: at("2025-03-19T12:00:00Z"), now: at("2025-03-19T12:05:00Z"), limit: 10,
+      want: []time.Time{at("2025-03-19T12:05:00Z")}},
+    {name: "several", from: at("2025-03-19T12:01:00Z"), now: at("2025-03-19T12:17:00Z"), limit: 10,
+      want: []time.Time{at("2025-03-19T12:05:00Z"), at("2025-03-19T12:10:00Z"), at("2025-03-19T12:15:00Z")}},
+    {name: "exactly limit", from: at("2025-03-19T12:00:00Z"), now: at("2025-03-19T12:10:00Z"), limit: 2,
+      want: []time.Time{at("2025-03-19T12:05:00Z"), at("2025-03-19T12:10:00Z")}},
+    {name: "over limit", from: at("2025-03-19T12:00:00Z"), now: at("2025-03-20T12:00:00Z"), limit: 2,
+      want: []time.Time{at("2025-03-19T12:05:00Z"), at("2025-03-19T12:10:00Z")}, truncated: true},
+  }
+
+  for _, tt := range tests {
+    t.Run(tt.name, func(t *testing.T) {
+      due, truncated := dueTicks(schedule, tt.from, tt.now, tt.limit)
+      if truncated != tt.truncated {
+        t.Errorf("truncated = %v, want %v", truncated, tt.truncated)
+      }
+      if len(due) != len(tt.want) {
+        t.Fatalf("due = %v, want %v", due, tt.want)
+      }
+      for i := range due {
+        if !due[i].Equal(tt.want[i]) {
+          t.Fatalf("due = %v, want %v", due, tt.want)
+        }
+      }
+    })
+  }
+}
+
+func TestNewValidation(t *testing.T) {
+  repo := &fakeRepo{ticks: make(map[string]time.Time)}
+  for _, maxCatchUp := range []int{0, -1} {
+    ctx := config.WrapCronContext(context.Background(), &config.Cron{MaxCatchUp: maxCatchUp})
+    if _, err := New(ctx, &fakeJobService{}, repo); err == nil {
+      t.Errorf("max_catch_up %d accepted", maxCatchUp)
+    }
+  }
+
+  invalid := []config.CronJob{
+    {Name: "", Schedule: "* * * * *"},
+    {Name: "job", Schedule: "not a schedule"},
+    {Name: "job", Schedule: "* * * * *", Timezone: "Mars/Olympus"},
+    {Name: "job", Schedule: "* * * * *", Missed: "sometimes"},
+    {Name: "job", Schedule: "* * * * *", Payload: "{broken"},
+  }
+  for _, job := range invalid {
+    ctx := config.WrapCronContext(context.Background(), &config.Cron{MaxCatchUp: 1, Jobs: []config.CronJob{job}})
+    if _, err := New(ctx, &fakeJobService{}, repo); err == nil {
+      t.Errorf("invalid job %+v accepted", job)
+    }
+  }
+
+  duplicate := []config.CronJob{{Name: "job", Schedule: "* * * * *"}, {Name: "job", Schedule: "@hourly"}}
+  ctx := config.WrapCronContext(context.Background(), &config.Cron{MaxCatchUp: 1, Jobs: duplicate})
+  if _, err := New(ctx, &fakeJobService{}, repo); err == nil {
+    t.Error("duplicate job names accepted")
+  }
+}
+
+func TestTickInitializesCursor(t *testing.T) {
+  s, repo, svc := newTestScheduler(t, 10, config.CronJob{Name: "job", Schedule: "* * * * *"})
+  now := at("2025-03-19T12:00:30Z")
+
+  s.tick(context.Background(), s.entries[0], now)
+  if len(svc.jobs) != 0 {
+    t.Errorf("%d jobs enqueued on first run", len(svc.jobs))
+  }
+  if !repo.ticks["job"].Equal(now) {
+    t.Errorf("cursor = %s, want %s", repo.ticks["job"], now)
+  }
+}
+
+func TestTickCatchUp(t *testing.T) {
+  s, repo, svc := newTestScheduler(t, 100, config.CronJob{
+    Name: "job", Schedule: "* * * * *", Missed: config.CronMissedCatch, Score: 5, Payload: `{"userId": 42}`,
+  })
+  repo.ticks["job"] = at("2025-03-19T11:50:00Z")
+
+  s.tick(context.Background(), s.entries[0], at("2025-03-19T12:00:30Z"))
+  if len(svc.jobs) != 10 {
+    t.Fatalf("%d jobs enqueued, want 10", len(svc.jobs))
+  }
+  if !repo.ticks["job"].Equal(at("2025-03-19T12:00:00Z")) {
+    t.Errorf("cursor = %s, want last tick", repo.ticks["job"])
+  }
+  // регистр ключей payload сохраняется
+  if job := svc.jobs[0]; job.Name != "job" || job.Score != 5 || string(job.Payload) != `{"userId": 42}` {
+    t.Errorf("unexpected job %+v", job)
+  }
+
+  // повторный опрос в ту же минуту ничего не ставит
+  s.tick(context.Background(), s.entries[0], at("2025-03-19T12:00:40Z"))
+  if len(svc.jobs) != 10 {
+    t.Errorf("tick enqueued twice: %d jobs", len(svc.jobs))
+  }
+}
+
+func TestTickSkip(t *testing.T) {
+  s, repo, svc := newTestScheduler(t, 100, config.CronJob{Name: "job", Schedule: "* * * * *"})
+  repo.ticks["job"] = at("2025-03-19T11:50:00Z")
+
+  s.tick(context.Background(), s.entries[0], at("2025-03-19T12:00:30Z"))
+  if len(svc.jobs) != 1 {
+    t.Fatalf("%d jobs enqueued, want only the current tick", len(svc.jobs))
+  }
+  if !repo.ticks["job"].Equal(at("2025-03-19T12:00:00Z")) {
+    t.Errorf("cursor = %s, want current tick", repo.ticks["job"])
+  }
+}
+
+func TestTickSkipNothingDue(t *testing.T) {
+  s, repo, svc := newTestScheduler(t, 100, config.CronJob{Name: "job", Schedule: "@hourly"})
+  repo.ticks["job"] = at("2025-03-19T12:00:00Z")
+
+  // до следующего тика курсор стоит на месте, опросы ничего не пишут
+  for _, now := range []string{"2025-03-19T12:05:00Z", "2025-03-19T12:30:00Z", "2025-03-19T12:59:59Z"} {
+    s.tick(context.Background(), s.entries[0], at(now))
+  }
+  if repo.claims != 0 || len(svc.jobs) != 0 {
+    t.Errorf("%d cursor writes and %d jobs before the next tick", repo.claims, len(svc.jobs))
+  }
+  if !repo.ticks["job"].Equal(at("2025-03-19T12:00:00Z")) {
+    t.Errorf("cursor moved to %s", repo.ticks["job"])
+  }
+
+  s.tick(context.Background(), s.entries[0], at("2025-03-19T13:00:10Z"))
+  if len(svc.jobs) != 1 || !repo.ticks["job"].Equal(at("2025-03-19T13:00:00Z")) {
+    t.Errorf("%d jobs, cursor %s after the tick", len(svc.jobs), repo.ticks["job"])
+  }
+}
+
+func TestTickCatchUpLimit(t *testing.T) {
+  s, repo, svc := newTestScheduler(t, 3, config.CronJob{
+    Name: "job", Schedule: "* * * * *", Missed: config.CronMissedCatch,
+  })
+  // курсор год назад: перебирать все тики не нужно, ставятся первые max_catch_up
+  repo.ticks["job"] = at("2024-03-19T12:00:00Z")
+  now := at("2025-03-19T12:00:30Z")
+
+  s.tick(context.Background(), s.entries[0], now)
+  if len(svc.jobs) != 3 {
+    t.Fatalf("%d jobs enqueued, want 3", len(svc.jobs))
+  }
+  if !repo.ticks["job"].Equal(now) {
+    t.Errorf("cursor = %s, want %s", repo.ticks["job"], now)
+  }
+
+  s.tick(context.Background(), s.entries[0], now.Add(10*time.Second))
+  if len(svc.jobs) != 3 {
+    t.Errorf("dropped ticks enqueued: %d jobs", len(svc.jobs))
+  }
+}
+
+func TestTickReleasesTickOnEnqueueError(t *testing.T) {
+  s, repo, svc := newTestScheduler(t, 100, config.CronJob{
+    Name: "job", Schedule: "* * * * *", Missed: config.CronMissedCatch,
+  })
+  repo.ticks["job"] = at("2025-03-19T11:57:00Z")
+  svc.failAt = 2
+
+  s.tick(context.Background(), s.entries[0], at("2025-03-19T12:00:30Z"))
+  if len(svc.jobs) != 1 {
+    t.Fatalf("%d jobs enqueued, want 1 before the failure", len(svc.jobs))
+  }
+  if !repo.ticks["job"].Equal(at("2025-03-19T11:58:00Z")) {
+    t.Fatalf("cursor = %s, want the last enqueued tick", repo.ticks["job"])
+  }
+
+  s.tick(context.Background(), s.entries[0], at("2025-03-19T12:00:40Z"))
+  if len(svc.jobs) != 3 {
+    t.Errorf("%d jobs enqueued, failed tick wasn't retried", len(svc.jobs))
+  }
+}
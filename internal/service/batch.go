@@ -0,0 +1,101 @@
+package service
+
+import (
+  "context"
+  "time"
+
+  "flussonic_tz/models"
+  "flussonic_tz/pkg/generator"
+
+  "github.com/pkg/errors"
+  "github.com/rs/zerolog/log"
+)
+
+// CreateBatch ставит пачку джоб с общим batch id и возвращает пачку вместе с id джоб в порядке запроса
+func (svc *JobService) CreateBatch(ctx context.Context, req *models.BatchRequest) (*models.Batch, []string, error) {
+  if len(req.Jobs) == 0 {
+    return nil, nil, errors.Wrap(ErrInvalidBatch, "no jobs")
+  }
+  // callback запускается по завершении пачки, отдельное расписание или зависимости ему не нужны. пока он ждёт,
+  // его хэш лежит вне очередей, поэтому срок жизни и уникальность для него тоже не поддерживаются
+  if req.Callback != nil && (req.Callback.RunAt != nil || req.Callback.Delay != 0 || len(req.Callback.DependsOn) > 0 ||
+    req.Callback.ExpiresAt != nil || req.Callback.TTL != 0 || req.Callback.UniqueKey != "") {
+    return nil, nil, errors.Wrap(ErrInvalidBatch, "callback can't have run_at, delay, depends_on, ttl or unique_key")
+  }
+
+  batchID, err := generator.GenerateID(32)
+  if err != nil {
+    log.Error().Err(err).Msg(err.Error())
+    return nil, nil, err
+  }
+
+  jobs := make([]*models.Job, 0, len(req.Jobs))
+  ids := make([]string, 0, len(req.Jobs))
+  for i := range req.Jobs {
+    // пропущенный дубликат оставил бы счётчик pending пачки ненулевым навсегда
+    if req.Jobs[i].UniqueKey != "" {
+      return nil, nil, errors.Wrapf(ErrInvalidBatch, "job %d: unique_key is not supported in batches", i)
+    }
+    // отсутствующий родитель обнаружится только внутри EXEC, а MULTI/EXEC не откатывает уже записанные джобы пачки
+    if len(req.Jobs[i].DependsOn) > 0 {
+      return nil, nil, errors.Wrapf(ErrInvalidBatch, "job %d: depends_on is not supported in batches", i)
+    }
+    id, err := generator.GenerateID(32)
+    if err != nil {
+      log.Error().Err(err).Msg(err.Error())
+      return nil, nil, err
+    }
+
+    job, err := newJob(id, &req.Jobs[i])
+    if err != nil {
+      return nil, nil, errors.Wrapf(err, "job %d", i)
+    }
+    job.BatchID = batchID
+    jobs = append(jobs, job)
+    ids = append(ids, id)
+  }
+
+  var callback *models.Job
+  if req.Callback != nil {
+    id, err := generator.GenerateID(32)
+    if err != nil {
+      log.Error().Err(err).Msg(err.Error())
+      return nil, nil, err
+    }
+    if callback, err = newJob(id, req.Callback); err != nil {
+      return nil, nil, errors.Wrap(err, "callback")
+    }
+  }
+
+  batch := &models.Batch{
+    ID:        batchID,
+    Status:    models.BatchRunning,
+    Total:     len(jobs),
+    Pending:   len(jobs),
+    CreatedAt: time.Now(),
+  }
+  if callback != nil {
+    batch.CallbackID = callback.ID
+  }
+
+  if err = svc.repo.AddBatch(ctx, batch, jobs, callback); err != nil {
+    return nil, nil, err
+  }
+
+  log.Info().Str("batch_id", batchID).Int("jobs", len(jobs)).Msg("batch created")
+  return batch, ids, nil
+}
+
+func (svc *JobService) GetBatch(ctx context.Context, batchID string) (*models.Batch, error) {
+  batch, err := svc.repo.GetBatch(ctx, batchID)
+  if err != nil {
+    return nil, err
+  }
+
+  batch.Status = models.BatchRunning
+  if batch.Pending == 0 {
+    batch.Status = models.BatchCompleted
+  }
+
+  return batch, nil
+}
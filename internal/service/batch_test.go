@@ -0,0 +1,83 @@
+package service
+
+import (
+  "context"
+  "testing"
+  "time"
+
+  "flussonic_tz/models"
+
+  "github.com/pkg/errors"
+)
+
+// batchRepo запоминает добавленную пачку, остальные методы репозитория тесту не нужны
+type batchRepo struct {
+  JobRepository
+  jobs     []*models.Job
+  callback *models.Job
+}
+
+func (r *batchRepo) AddBatch(_ context.Context, _ *models.Batch, jobs []*models.Job, callback *models.Job) error {
+  r.jobs, r.callback = jobs, callback
+  return nil
+}
+
+func TestCreateBatch(t *testing.T) {
+  repo := &batchRepo{}
+  svc := NewJobService(repo)
+  req := &models.BatchRequest{
+    Jobs:     []models.JobRequest{{Name: "job", Score: 1}, {Name: "job", Score: 2}},
+    Callback: &models.JobRequest{Name: "callback"},
+  }
+
+  batch, ids, err := svc.CreateBatch(context.Background(), req)
+  if err != nil {
+    t.Fatal(err)
+  }
+  if batch.Total != 2 || batch.Pending != 2 || len(ids) != 2 || len(repo.jobs) != 2 {
+    t.Fatalf("batch %+v, ids %v", batch, ids)
+  }
+  for i, job := range repo.jobs {
+    if job.ID != ids[i] || job.BatchID != batch.ID {
+      t.Errorf("job %d: %+v", i, job)
+    }
+  }
+  if repo.callback == nil || batch.CallbackID != repo.callback.ID {
+    t.Errorf("callback %+v, batch callback id %s", repo.callback, batch.CallbackID)
+  }
+}
+
+func TestCreateBatchValidation(t *testing.T) {
+  runAt := time.Now().Add(time.Hour)
+  tests := []struct {
+    name string
+    req  models.BatchRequest
+  }{
+    {name: "no jobs"},
+    {name: "unique_key", req: models.BatchRequest{Jobs: []models.JobRequest{{Name: "job", UniqueKey: "k"}}}},
+    {name: "depends_on", req: models.BatchRequest{Jobs: []models.JobRequest{
+      {Name: "job", DependsOn: []models.Dependency{{ID: "parent"}}},
+    }}},
+    {name: "callback run_at", req: models.BatchRequest{Jobs: []models.JobRequest{{Name: "job"}},
+      Callback: &models.JobRequest{Name: "callback", RunAt: &runAt}}},
+    {name: "callback depends_on", req: models.BatchRequest{Jobs: []models.JobRequest{{Name: "job"}},
+      Callback: &models.JobRequest{Name: "callback", DependsOn: []models.Dependency{{ID: "parent"}}}}},
+    {name: "callback ttl", req: models.BatchRequest{Jobs: []models.JobRequest{{Name: "job"}},
+      Callback: &models.JobRequest{Name: "callback", TTL: models.Duration(time.Hour)}}},
+    {name: "callback unique_key", req: models.BatchRequest{Jobs: []models.JobRequest{{Name: "job"}},
+      Callback: &models.JobRequest{Name: "callback", UniqueKey: "k"}}},
+  }
+
+  for _, tt := range tests {
+    t.Run(tt.name, func(t *testing.T) {
+      repo := &batchRepo{}
+      _, _, err := NewJobService(repo).CreateBatch(context.Background(), &tt.req)
+      if !errors.Is(err, ErrInvalidBatch) {
+        t.Fatalf("expected ErrInvalidBatch, got %v", err)
+      }
+      if repo.jobs != nil {
+        t.Error("invalid batch reached the repository")
+      }
+    })
+  }
+}
@@ -0,0 +1,105 @@
+package service
+
+import (
+  "bytes"
+  "context"
+  "crypto/sha256"
+  "encoding/hex"
+  "encoding/json"
+  "time"
+
+  errs "flussonic_tz/internal/errors"
+  "flussonic_tz/models"
+
+  "github.com/pkg/errors"
+  "github.com/rs/zerolog/log"
+)
+
+const (
+  StatusCreated = "created"
+  StatusExists  = "exists"
+
+  // на сколько занимается ключ, пока создаётся джоба. если процесс упадёт до сохранения ответа, ключ освободится
+  // сам, а не будет отвечать "in progress" весь idempotency_ttl
+  IdempotencyReservationTTL = 30 * time.Second
+)
+
+// CreateJobIdempotent создаёт джобу не больше одного раза на idempotency key. повтор с тем же запросом возвращает
+// сохранённую запись и true, с другим запросом - ErrIdempotencyMismatch. пока первый запрос ещё выполняется,
+// повтор получает ErrIdempotencyInProgress
+func (svc *JobService) CreateJobIdempotent(ctx context.Context, key string, ttl time.Duration,
+  req *models.JobRequest) (*models.IdempotencyRecord, bool, error) {
+  fingerprint, err := requestFingerprint(req)
+  if err != nil {
+    log.Error().Err(err).Msg(err.Error())
+    return nil, false, err
+  }
+
+  record, reserved, err := svc.repo.ReserveIdempotencyKey(ctx, key,
+    &models.IdempotencyRecord{Fingerprint: fingerprint, CreatedAt: time.Now()}, min(ttl, IdempotencyReservationTTL))
+  if err != nil {
+    return nil, false, err
+  }
+  if !reserved {
+    if record.Fingerprint != fingerprint {
+      return nil, false, ErrIdempotencyMismatch
+    }
+    if record.JobID == "" {
+      return nil, false, ErrIdempotencyInProgress
+    }
+    return record, true, nil
+  }
+
+  id, err := svc.CreateJob(ctx, req)
+  record.JobID, record.Status = id, StatusCreated
+  var duplicate *DuplicateJobError
+  if errors.As(err, &duplicate) {
+    record.JobID, record.Status, err = duplicate.ID, StatusExists, nil
+  }
+  if err != nil {
+    // ошибки валидации и redis не кэшируем, запрос можно повторить с тем же ключом
+    if releaseErr := svc.repo.ReleaseIdempotencyKey(ctx, key); releaseErr != nil {
+      log.Error().Err(releaseErr).Msg(releaseErr.Error())
+    }
+    return nil, false, err
+  }
+
+  // джоба уже создана, поэтому ошибку сохранения только логируем. ключ освобождается, чтобы повтор не получал
+  // "in progress", пока не истечёт резерв
+  if err = svc.repo.SaveIdempotencyKey(ctx, key, record, ttl); err != nil {
+    log.Error().Err(err).Str("job_id", record.JobID).Msg(err.Error())
+    if releaseErr := svc.repo.ReleaseIdempotencyKey(ctx, key); releaseErr != nil {
+      log.Error().Err(releaseErr).Msg(releaseErr.Error())
+    }
+  }
+
+  return record, false, nil
+}
+
+// requestFingerprint считается по разобранному запросу, поэтому не зависит от форматирования и порядка полей JSON.
+// payload хранится как есть, поэтому перед подсчётом он нормализуется: ключи объектов сортируются, пробелы убираются
+func requestFingerprint(req *models.JobRequest) (string, error) {
+  normalized := *req
+  if len(req.Payload) > 0 {
+    decoder := json.NewDecoder(bytes.NewReader(req.Payload))
+    // числа остаются в исходной записи, иначе большие целые склеились бы при переводе во float64
+    decoder.UseNumber()
+    var payload interface{}
+    if err := decoder.Decode(&payload); err != nil {
+      return "", errors.Wrap(err, errs.ErrFingerprintRequest)
+    }
+    data, err := json.Marshal(payload)
+    if err != nil {
+      return "", errors.Wrap(err, errs.ErrFingerprintRequest)
+    }
+    normalized.Payload = data
+  }
+
+  data, err := json.Marshal(&normalized)
+  if err != nil {
+    return "", errors.Wrap(err, errs.ErrFingerprintRequest)
+  }
+
+  sum := sha256.Sum256(data)
+  return hex.EncodeToString(sum[:]), nil
+}
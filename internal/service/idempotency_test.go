@@ -0,0 +1,140 @@
+package service
+
+import (
+  "context"
+  "encoding/json"
+  "testing"
+  "time"
+
+  "flussonic_tz/models"
+
+  "github.com/pkg/errors"
+)
+
+// idempotencyRepo хранит ключи в памяти, остальные методы репозитория тесту не нужны
+type idempotencyRepo struct {
+  JobRepository
+  records    map[string]*models.IdempotencyRecord
+  ttls       map[string]time.Duration
+  reserveTTL time.Duration
+  saveErr    error
+}
+
+func newIdempotencyRepo() *idempotencyRepo {
+  return &idempotencyRepo{
+    records: make(map[string]*models.IdempotencyRecord),
+    ttls:    make(map[string]time.Duration),
+  }
+}
+
+func (r *idempotencyRepo) AddJob(_ context.Context, _ *models.Job) error {
+  return nil
+}
+
+func (r *idempotencyRepo) ReserveIdempotencyKey(_ context.Context, key string, record *models.IdempotencyRecord,
+  ttl time.Duration) (*models.IdempotencyRecord, bool, error) {
+  r.reserveTTL = ttl
+  if existing, ok := r.records[key]; ok {
+    return existing, false, nil
+  }
+  stored := *record
+  r.records[key], r.ttls[key] = &stored, ttl
+  return record, true, nil
+}
+
+func (r *idempotencyRepo) SaveIdempotencyKey(_ context.Context, key string, record *models.IdempotencyRecord,
+  ttl time.Duration) error {
+  if r.saveErr != nil {
+    return r.saveErr
+  }
+  stored := *record
+  r.records[key], r.ttls[key] = &stored, ttl
+  return nil
+}
+
+func (r *idempotencyRepo) ReleaseIdempotencyKey(_ context.Context, key string) error {
+  delete(r.records, key)
+  delete(r.ttls, key)
+  return nil
+}
+
+func TestCreateJobIdempotent(t *testing.T) {
+  repo := newIdempotencyRepo()
+  svc := NewJobService(repo)
+  ctx := context.Background()
+  req := &models.JobRequest{Name: "job", Score: 1, Payload: json.RawMessage(`{"a": 1}`)}
+
+  record, replayed, err := svc.CreateJobIdempotent(ctx, "key", 24*time.Hour, req)
+  if err != nil || replayed || record.Status != StatusCreated {
+    t.Fatalf("first request: record %+v, replayed %v, err %v", record, replayed, err)
+  }
+  if repo.ttls["key"] != 24*time.Hour {
+    t.Errorf("saved record ttl %s, want idempotency ttl", repo.ttls["key"])
+  }
+
+  again, replayed, err := svc.CreateJobIdempotent(ctx, "key", 24*time.Hour, req)
+  if err != nil || !replayed || again.JobID != record.JobID {
+    t.Fatalf("replay: record %+v, replayed %v, err %v", again, replayed, err)
+  }
+
+  other := &models.JobRequest{Name: "job", Score: 2}
+  if _, _, err = svc.CreateJobIdempotent(ctx, "key", 24*time.Hour, other); !errors.Is(err, ErrIdempotencyMismatch) {
+    t.Errorf("different request: err %v, want ErrIdempotencyMismatch", err)
+  }
+}
+
+func TestCreateJobIdempotentReservation(t *testing.T) {
+  repo := newIdempotencyRepo()
+  svc := NewJobService(repo)
+  ctx := context.Background()
+  req := &models.JobRequest{Name: "job", Score: 1}
+
+  // пока джоба создаётся, ключ занят ненадолго, чтобы после падения процесса он освободился сам
+  if _, _, err := svc.CreateJobIdempotent(ctx, "key", 24*time.Hour, req); err != nil {
+    t.Fatal(err)
+  }
+  if repo.reserveTTL != IdempotencyReservationTTL {
+    t.Errorf("reservation ttl %s, want %s", repo.reserveTTL, IdempotencyReservationTTL)
+  }
+
+  repo.saveErr = errors.New("redis is down")
+  if _, _, err := svc.CreateJobIdempotent(ctx, "failed-save", 24*time.Hour, req); err != nil {
+    t.Fatalf("job was created, save error must not fail the request: %v", err)
+  }
+  if _, ok := repo.records["failed-save"]; ok {
+    t.Error("key is still reserved after the record couldn't be saved")
+  }
+}
+
+func TestRequestFingerprint(t *testing.T) {
+  fingerprint := func(payload string) string {
+    t.Helper()
+    value, err := requestFingerprint(&models.JobRequest{Name: "job", Score: 1, Payload: json.RawMessage(payload)})
+    if err != nil {
+      t.Fatal(err)
+    }
+    return value
+  }
+
+  base := fingerprint(`{"to": "user@example.com", "tags": [1, 2], "meta": {"a": 1, "b": 2}}`)
+  same := fingerprint(`{"meta":{"b":2,"a":1},"tags":[1,2],"to":"user@example.com"}`)
+  if base != same {
+    t.Error("fingerprint depends on payload key order or formatting")
+  }
+
+  different := []string{
+    `{"to": "admin@example.com", "tags": [1, 2], "meta": {"a": 1, "b": 2}}`,
+    `{"to": "user@example.com", "tags": [2, 1], "meta": {"a": 1, "b": 2}}`,
+    `{"to": "user@example.com", "tags": [1, 2], "meta": {"a": 1}}`,
+  }
+  for _, payload := range different {
+    if fingerprint(payload) == base {
+      t.Errorf("payload %s has the same fingerprint", payload)
+    }
+  }
+
+  // большие целые не должны склеиваться при нормализации
+  if fingerprint(`{"id": 9007199254740993}`) == fingerprint(`{"id": 9007199254740992}`) {
+    t.Error("large integers collide")
+  }
+}
@@ -2,20 +2,87 @@ package service
 
 import (
   "context"
+  "fmt"
   "time"
 
+  errs "flussonic_tz/internal/errors"
   "flussonic_tz/models"
   "flussonic_tz/pkg/generator"
 
+  "github.com/pkg/errors"
   "github.com/rs/zerolog/log"
 )
 
+var (
+  ErrIllegalTransition = errors.New(errs.ErrIllegalTransition)
+  ErrQueueEmpty        = errors.New(errs.ErrQueueEmpty)
+  ErrResultTooLarge    = errors.New(errs.ErrResultTooLarge)
+  ErrJobNotFound       = errors.New(errs.ErrJobNotFound)
+  ErrResultNotReady    = errors.New(errs.ErrResultNotReady)
+  ErrResultNotFound    = errors.New(errs.ErrResultNotFound)
+  ErrNoResult          = errors.New(errs.ErrNoResult)
+  ErrNotInDeadLetter   = errors.New(errs.ErrNotInDeadLetter)
+  ErrInvalidSchedule   = errors.New(errs.ErrInvalidSchedule)
+
+  ErrInvalidDependency  = errors.New(errs.ErrInvalidDependency)
+  ErrDependencyNotFound = errors.New(errs.ErrDependencyNotFound)
+  ErrWorkflowNotFound   = errors.New(errs.ErrWorkflowNotFound)
+  ErrInvalidWorkflow    = errors.New(errs.ErrInvalidWorkflow)
+  ErrBatchNotFound      = errors.New(errs.ErrBatchNotFound)
+  ErrInvalidBatch       = errors.New(errs.ErrInvalidBatch)
+  ErrInvalidUnique      = errors.New(errs.ErrInvalidUnique)
+
+  ErrIdempotencyMismatch   = errors.New(errs.ErrIdempotencyMismatch)
+  ErrIdempotencyInProgress = errors.New(errs.ErrIdempotencyInProgress)
+)
+
+// DuplicateJobError - уникальная джоба с таким unique_key уже есть, ID - её id
+type DuplicateJobError struct {
+  ID string
+}
+
+func (e *DuplicateJobError) Error() string {
+  return fmt.Sprintf("%s: %s", errs.ErrDuplicateJob, e.ID)
+}
+
 type JobRepository interface {
   AddJob(ctx context.Context, job *models.Job) error
-  GetJob(ctx context.Context) (*models.Job, error)
-  CompleteJob(ctx context.Context, jobID string) error
-  FailJob(ctx context.Context, jobID string) error
+  GetJob(ctx context.Context, lease time.Duration) (*models.Job, error)
+  WaitForJob(ctx context.Context, timeout time.Duration) (bool, error)
+  CompleteJob(ctx context.Context, jobID string, result []byte) error
+  FailJob(ctx context.Context, jobID string, record models.AttemptRecord) error
+  RetryJob(ctx context.Context, job *models.Job, runAt time.Time, record models.AttemptRecord) error
+  PromoteJobs(ctx context.Context, now time.Time) (int, error)
+  CancelJob(ctx context.Context, jobID, reason string) (string, error)
+  WatchCancellations(ctx context.Context, handle func(jobID string)) error
+  InterruptJob(ctx context.Context, jobID string) error
+  RenewLease(ctx context.Context, jobID string, lease time.Duration) (bool, error)
+  RequeueExpired(ctx context.Context, now time.Time, maxRedeliveries int) (int, int, error)
+  ExpireJobs(ctx context.Context, now time.Time) (int, error)
   GetJobStatus(ctx context.Context, jobID string) (string, error)
+  GetJobResult(ctx context.Context, jobID string) ([]byte, error)
+  QueueDepth(ctx context.Context) (int64, error)
+  RecordPanic(ctx context.Context, jobID, value, stack string) error
+  SetProgress(ctx context.Context, jobID string, delivery, percent int, message string, at time.Time) error
+  RegisterInstance(ctx context.Context, instance *models.Instance, ttl time.Duration) error
+  UnregisterInstance(ctx context.Context, instanceID string) error
+  ListInstances(ctx context.Context, now time.Time) ([]models.Instance, error)
+  ListDeadJobs(ctx context.Context, offset, limit int) (*models.DeadJobsPage, error)
+  GetDeadJob(ctx context.Context, jobID string) (*models.DeadJob, error)
+  ReplayDeadJobs(ctx context.Context, jobIDs []string) (int, []models.ReplayRejection, error)
+  PurgeDeadJobs(ctx context.Context, jobIDs []string) (int, error)
+  CronLastTick(ctx context.Context, name string) (time.Time, bool, error)
+  InitCronTick(ctx context.Context, name string, tick time.Time) error
+  ClaimCronTick(ctx context.Context, name string, tick time.Time) (bool, error)
+  ReleaseCronTick(ctx context.Context, name string, tick, previous time.Time) error
+  AddWorkflow(ctx context.Context, workflow *models.Workflow, jobs []*models.Job) error
+  GetWorkflow(ctx context.Context, workflowID string) (*models.Workflow, error)
+  AddBatch(ctx context.Context, batch *models.Batch, jobs []*models.Job, callback *models.Job) error
+  GetBatch(ctx context.Context, batchID string) (*models.Batch, error)
+  ReserveIdempotencyKey(ctx context.Context, key string, record *models.IdempotencyRecord,
+    ttl time.Duration) (*models.IdempotencyRecord, bool, error)
+  SaveIdempotencyKey(ctx context.Context, key string, record *models.IdempotencyRecord, ttl time.Duration) error
+  ReleaseIdempotencyKey(ctx context.Context, key string) error
 }
 
 type JobService struct {
@@ -28,26 +95,194 @@ func NewJobService(repo JobRepository) *JobService {
   }
 }
 
-func (svc *JobService) CreateJob(ctx context.Context, name string, score float64) (string, error) {
+func (svc *JobService) CreateJob(ctx context.Context, req *models.JobRequest) (string, error) {
   id, err := generator.GenerateID(32)
   if err != nil {
     log.Error().Err(err).Msg(err.Error())
     return "", err
   }
 
-  job := &models.Job{
-    ID:        id,
-    Name:      name,
-    Score:     score,
-    Status:    "pending",
-    CreatedAt: time.Now(),
+  job, err := newJob(id, req)
+  if err != nil {
+    return "", err
   }
 
   return id, svc.repo.AddJob(ctx, job)
 }
 
-func (svc *JobService) GetJob(ctx context.Context) (*models.Job, error) {
-  job, err := svc.repo.GetJob(ctx)
+// newJob проверяет запрос и собирает из него джобу
+func newJob(id string, req *models.JobRequest) (*models.Job, error) {
+  if req.RunAt != nil && req.Delay != 0 {
+    return nil, errors.Wrap(ErrInvalidSchedule, "both run_at and delay are set")
+  }
+  if req.Delay < 0 {
+    return nil, errors.Wrap(ErrInvalidSchedule, "negative delay")
+  }
+  // время запуска ждущей джобы зависит от родителей, отложить её ещё и по времени нельзя
+  if len(req.DependsOn) > 0 && (req.RunAt != nil || req.Delay != 0) {
+    return nil, errors.Wrap(ErrInvalidSchedule, "depends_on can't be combined with run_at or delay")
+  }
+
+  dependsOn, err := dependencies(req.DependsOn)
+  if err != nil {
+    return nil, err
+  }
+  if err = validateUnique(req); err != nil {
+    return nil, err
+  }
+  if err = validateRetry(req.Retry); err != nil {
+    return nil, err
+  }
+
+  now := time.Now()
+  var runAt time.Time
+  switch {
+  case req.RunAt != nil:
+    runAt = *req.RunAt
+  case req.Delay > 0:
+    runAt = now.Add(time.Duration(req.Delay))
+  }
+
+  expiresAt, err := expiration(req, now, runAt)
+  if err != nil {
+    return nil, err
+  }
+
+  return &models.Job{
+    ID:      id,
+    Name:    req.Name,
+    Score:   req.Score,
+    Status:  "pending",
+    Payload: req.Payload,
+    Retry:   req.Retry,
+
+    MaxRedeliveries: req.MaxRedeliveries,
+    DependsOn:       dependsOn,
+    UniqueKey:       req.UniqueKey,
+    UniqueScope:     req.UniqueScope,
+    UniqueTTL:       time.Duration(req.UniqueTTL),
+    CreatedAt:       now,
+    RunAt:           runAt,
+    ExpiresAt:       expiresAt,
+  }, nil
+}
+
+// expiration возвращает момент, после которого джобу уже нет смысла запускать, или нулевое время, если он не задан
+func expiration(req *models.JobRequest, now, runAt time.Time) (time.Time, error) {
+  if req.ExpiresAt != nil && req.TTL != 0 {
+    return time.Time{}, errors.Wrap(ErrInvalidSchedule, "both expires_at and ttl are set")
+  }
+  if req.TTL < 0 {
+    return time.Time{}, errors.Wrap(ErrInvalidSchedule, "negative ttl")
+  }
+
+  var expiresAt time.Time
+  switch {
+  case req.ExpiresAt != nil:
+    expiresAt = *req.ExpiresAt
+  case req.TTL > 0:
+    expiresAt = now.Add(time.Duration(req.TTL))
+  default:
+    return time.Time{}, nil
+  }
+
+  if !expiresAt.After(now) {
+    return time.Time{}, errors.Wrap(ErrInvalidSchedule, "job is already expired")
+  }
+  if !runAt.IsZero() && !expiresAt.After(runAt) {
+    return time.Time{}, errors.Wrap(ErrInvalidSchedule, "job expires before run_at")
+  }
+
+  return expiresAt, nil
+}
+
+// validateUnique проверяет scope уникальности и подставляет scope по умолчанию
+func validateUnique(req *models.JobRequest) error {
+  if req.UniqueKey == "" {
+    if req.UniqueScope != "" || req.UniqueTTL != 0 {
+      return errors.Wrap(ErrInvalidUnique, "unique_scope without unique_key")
+    }
+    return nil
+  }
+
+  switch req.UniqueScope {
+  case "":
+    req.UniqueScope = models.UniqueScopeActive
+  case models.UniqueScopePending, models.UniqueScopeActive:
+  case models.UniqueScopeTTL:
+    if req.UniqueTTL < models.Duration(time.Millisecond) {
+      return errors.Wrap(ErrInvalidUnique, "ttl scope requires unique_ttl")
+    }
+    return nil
+  default:
+    return errors.Wrapf(ErrInvalidUnique, "unknown unique_scope %q", req.UniqueScope)
+  }
+  if req.UniqueTTL != 0 {
+    return errors.Wrap(ErrInvalidUnique, "unique_ttl is only allowed with ttl scope")
+  }
+
+  return nil
+}
+
+// validateRetry проверяет политику ретраев из запроса: отрицательные значения и jitter вне [0, 1] не имеют смысла
+func validateRetry(policy *models.RetryPolicy) error {
+  if policy == nil {
+    return nil
+  }
+  if policy.MaxAttempts < 0 {
+    return errors.Wrap(ErrInvalidSchedule, "negative retry max_attempts")
+  }
+  if policy.InitialDelay < 0 || policy.MaxDelay < 0 {
+    return errors.Wrap(ErrInvalidSchedule, "negative retry delay")
+  }
+  for _, delay := range policy.Schedule {
+    if delay < 0 {
+      return errors.Wrap(ErrInvalidSchedule, "negative delay in retry schedule")
+    }
+  }
+  if policy.Jitter < 0 || policy.Jitter > 1 {
+    return errors.Wrap(ErrInvalidSchedule, "retry jitter must be between 0 and 1")
+  }
+
+  return nil
+}
+
+// dependencies проверяет рёбра и подставляет политику по умолчанию. повтор родителя запрещён, иначе джоба ждала бы
+// его завершения дважды
+func dependencies(deps []models.Dependency) ([]models.Dependency, error) {
+  if len(deps) == 0 {
+    return nil, nil
+  }
+
+  seen := make(map[string]struct{}, len(deps))
+  result := make([]models.Dependency, 0, len(deps))
+  for _, dep := range deps {
+    if dep.ID == "" {
+      return nil, errors.Wrap(ErrInvalidDependency, "empty id")
+    }
+    if _, ok := seen[dep.ID]; ok {
+      return nil, errors.Wrapf(ErrInvalidDependency, "duplicate %s", dep.ID)
+    }
+    seen[dep.ID] = struct{}{}
+
+    switch dep.OnFailure {
+    case "":
+      dep.OnFailure = models.OnFailureCancel
+    case models.OnFailureCancel, models.OnFailureFail:
+    default:
+      return nil, errors.Wrapf(ErrInvalidDependency, "unknown on_failure %q", dep.OnFailure)
+    }
+    result = append(result, dep)
+  }
+
+  return result, nil
+}
+
+func (svc *JobService) GetJob(ctx context.Context, lease time.Duration) (*models.Job, error) {
+  job, err := svc.repo.GetJob(ctx, lease)
+  if errors.Is(err, ErrQueueEmpty) {
+    return nil, err
+  }
   if err != nil {
     log.Error().Err(err).Msg(err.Error())
     return nil, err
@@ -65,3 +300,47 @@ func (svc *JobService) GetJobStatus(ctx context.Context, jobID string) (string,
 
   return status, nil
 }
+
+// CancelJob отменяет джобу в любом незавершённом статусе и возвращает статус, в котором она была
+func (svc *JobService) CancelJob(ctx context.Context, jobID, reason string) (string, error) {
+  previous, err := svc.repo.CancelJob(ctx, jobID, reason)
+  if err != nil {
+    return "", err
+  }
+
+  log.Info().Str("job_id", jobID).Str("previous_status", previous).Str("reason", reason).Msg("job cancelled")
+  return previous, nil
+}
+
+func (svc *JobService) GetJobResult(ctx context.Context, jobID string) ([]byte, error) {
+  return svc.repo.GetJobResult(ctx, jobID)
+}
+
+func (svc *JobService) ListDeadJobs(ctx context.Context, offset, limit int) (*models.DeadJobsPage, error) {
+  return svc.repo.ListDeadJobs(ctx, offset, limit)
+}
+
+func (svc *JobService) GetDeadJob(ctx context.Context, jobID string) (*models.DeadJob, error) {
+  return svc.repo.GetDeadJob(ctx, jobID)
+}
+
+func (svc *JobService) ReplayDeadJobs(ctx context.Context, jobIDs []string) (int, []models.ReplayRejection, error) {
+  replayed, rejected, err := svc.repo.ReplayDeadJobs(ctx, jobIDs)
+  if err != nil {
+    return 0, nil, err
+  }
+
+  log.Info().Int("requested", len(jobIDs)).Int("replayed", replayed).Int("rejected", len(rejected)).
+    Msg("dead jobs replayed")
+  return replayed, rejected, nil
+}
+
+func (svc *JobService) PurgeDeadJobs(ctx context.Context, jobIDs []string) (int, error) {
+  purged, err := svc.repo.PurgeDeadJobs(ctx, jobIDs)
+  if err != nil {
+    return purged, err
+  }
+
+  log.Info().Int("purged", purged).Msg("dead jobs purged")
+  return purged, nil
+}
@@ -0,0 +1,137 @@
+package service
+
+import (
+  "encoding/json"
+  "testing"
+  "time"
+
+  "flussonic_tz/models"
+
+  "github.com/pkg/errors"
+)
+
+func TestNewJobRetryValidation(t *testing.T) {
+  tests := []struct {
+    name    string
+    retry   string
+    wantErr bool
+  }{
+    {name: "valid", retry: `{"max_attempts": 5, "initial_delay": "1s", "max_delay": "1m", "jitter": 0.2}`},
+    {name: "schedule", retry: `{"schedule": ["1s", "5s"]}`},
+    {name: "full jitter", retry: `{"jitter": 1}`},
+    {name: "negative max_attempts", retry: `{"max_attempts": -1}`, wantErr: true},
+    {name: "negative initial_delay", retry: `{"initial_delay": "-1s"}`, wantErr: true},
+    {name: "negative max_delay", retry: `{"max_delay": "-1s"}`, wantErr: true},
+    {name: "negative schedule delay", retry: `{"schedule": ["1s", "-5s"]}`, wantErr: true},
+    {name: "negative jitter", retry: `{"jitter": -0.1}`, wantErr: true},
+    {name: "jitter above one", retry: `{"jitter": 1.5}`, wantErr: true},
+  }
+
+  for _, tt := range tests {
+    t.Run(tt.name, func(t *testing.T) {
+      req := &models.JobRequest{Name: "job", Retry: &models.RetryPolicy{}}
+      if err := json.Unmarshal([]byte(tt.retry), req.Retry); err != nil {
+        t.Fatal(err)
+      }
+
+      _, err := newJob("id", req)
+      if tt.wantErr && !errors.Is(err, ErrInvalidSchedule) {
+        t.Errorf("expected ErrInvalidSchedule, got %v", err)
+      }
+      if !tt.wantErr && err != nil {
+        t.Errorf("unexpected error: %v", err)
+      }
+    })
+  }
+}
+
+func TestValidateUnique(t *testing.T) {
+  tests := []struct {
+    name      string
+    req       models.JobRequest
+    wantScope string
+    wantErr   bool
+  }{
+    {name: "no key", req: models.JobRequest{}},
+    {name: "default scope", req: models.JobRequest{UniqueKey: "k"}, wantScope: models.UniqueScopeActive},
+    {name: "pending", req: models.JobRequest{UniqueKey: "k", UniqueScope: models.UniqueScopePending},
+      wantScope: models.UniqueScopePending},
+    {name: "ttl", req: models.JobRequest{UniqueKey: "k", UniqueScope: models.UniqueScopeTTL,
+      UniqueTTL: models.Duration(time.Minute)}, wantScope: models.UniqueScopeTTL},
+    {name: "scope without key", req: models.JobRequest{UniqueScope: models.UniqueScopePending}, wantErr: true},
+    {name: "unique_ttl without key", req: models.JobRequest{UniqueTTL: models.Duration(time.Minute)}, wantErr: true},
+    {name: "unknown scope", req: models.JobRequest{UniqueKey: "k", UniqueScope: "forever"}, wantErr: true},
+    {name: "ttl scope without unique_ttl", req: models.JobRequest{UniqueKey: "k", UniqueScope: models.UniqueScopeTTL},
+      wantErr: true},
+    {name: "ttl scope below millisecond", req: models.JobRequest{UniqueKey: "k", UniqueScope: models.UniqueScopeTTL,
+      UniqueTTL: models.Duration(time.Microsecond)}, wantErr: true},
+    {name: "unique_ttl with active scope", req: models.JobRequest{UniqueKey: "k",
+      UniqueTTL: models.Duration(time.Minute)}, wantErr: true},
+  }
+
+  for _, tt := range tests {
+    t.Run(tt.name, func(t *testing.T) {
+      err := validateUnique(&tt.req)
+      if tt.wantErr {
+        if !errors.Is(err, ErrInvalidUnique) {
+          t.Fatalf("expected ErrInvalidUnique, got %v", err)
+        }
+        return
+      }
+      if err != nil {
+        t.Fatalf("unexpected error: %v", err)
+      }
+      if tt.req.UniqueScope != tt.wantScope {
+        t.Errorf("scope = %q, want %q", tt.req.UniqueScope, tt.wantScope)
+      }
+    })
+  }
+}
+
+func TestExpiration(t *testing.T) {
+  now := time.Now()
+  ptr := func(value time.Time) *time.Time {
+    return &value
+  }
+
+  tests := []struct {
+    name    string
+    req     models.JobRequest
+    runAt   time.Time
+    want    time.Time
+    wantErr bool
+  }{
+    {name: "not set", req: models.JobRequest{}},
+    {name: "ttl", req: models.JobRequest{TTL: models.Duration(time.Hour)}, want: now.Add(time.Hour)},
+    {name: "expires_at", req: models.JobRequest{ExpiresAt: ptr(now.Add(time.Hour))}, want: now.Add(time.Hour)},
+    {name: "expires after run_at", req: models.JobRequest{TTL: models.Duration(time.Hour)}, runAt: now.Add(time.Minute),
+      want: now.Add(time.Hour)},
+    {name: "both set", req: models.JobRequest{TTL: models.Duration(time.Hour), ExpiresAt: ptr(now.Add(time.Hour))},
+      wantErr: true},
+    {name: "negative ttl", req: models.JobRequest{TTL: models.Duration(-time.Hour)}, wantErr: true},
+    {name: "already expired", req: models.JobRequest{ExpiresAt: ptr(now.Add(-time.Second))}, wantErr: true},
+    {name: "expires at now", req: models.JobRequest{ExpiresAt: ptr(now)}, wantErr: true},
+    {name: "expires before run_at", req: models.JobRequest{TTL: models.Duration(time.Minute)},
+      runAt: now.Add(time.Hour), wantErr: true},
+    {name: "expires at run_at", req: models.JobRequest{ExpiresAt: ptr(now.Add(time.Hour))}, runAt: now.Add(time.Hour),
+      wantErr: true},
+  }
+
+  for _, tt := range tests {
+    t.Run(tt.name, func(t *testing.T) {
+      expiresAt, err := expiration(&tt.req, now, tt.runAt)
+      if tt.wantErr {
+        if !errors.Is(err, ErrInvalidSchedule) {
+          t.Fatalf("expected ErrInvalidSchedule, got %v", err)
+        }
+        return
+      }
+      if err != nil {
+        t.Fatalf("unexpected error: %v", err)
+      }
+      if !expiresAt.Equal(tt.want) {
+        t.Errorf("expires_at = %s, want %s", expiresAt, tt.want)
+      }
+    })
+  }
+}
@@ -0,0 +1,155 @@
+package service
+
+import (
+  "context"
+  "time"
+
+  "flussonic_tz/models"
+  "flussonic_tz/pkg/generator"
+
+  "github.com/pkg/errors"
+  "github.com/rs/zerolog/log"
+)
+
+// CreateWorkflow ставит весь граф джоб одной транзакцией. ссылки depends_on по key заменяются на id джоб, а сами
+// джобы добавляются в топологическом порядке, чтобы к моменту добавления джобы все её родители уже были в redis
+func (svc *JobService) CreateWorkflow(ctx context.Context, req *models.WorkflowRequest) (*models.Workflow, error) {
+  order, err := workflowOrder(req.Jobs)
+  if err != nil {
+    return nil, err
+  }
+
+  workflowID, err := generator.GenerateID(32)
+  if err != nil {
+    log.Error().Err(err).Msg(err.Error())
+    return nil, err
+  }
+
+  ids := make(map[string]string, len(req.Jobs))
+  for _, job := range req.Jobs {
+    if ids[job.Key], err = generator.GenerateID(32); err != nil {
+      log.Error().Err(err).Msg(err.Error())
+      return nil, err
+    }
+  }
+
+  workflow := &models.Workflow{
+    ID:        workflowID,
+    CreatedAt: time.Now(),
+    Jobs:      make([]models.WorkflowJob, 0, len(req.Jobs)),
+  }
+  jobs := make([]*models.Job, 0, len(order))
+  for _, i := range order {
+    // дубликат не попал бы в граф, и зависящие от него джобы ждали бы вечно
+    if req.Jobs[i].UniqueKey != "" {
+      return nil, errors.Wrapf(ErrInvalidWorkflow, "job %s: unique_key is not supported in workflows", req.Jobs[i].Key)
+    }
+    jobReq := req.Jobs[i].JobRequest
+    jobReq.DependsOn = make([]models.Dependency, 0, len(req.Jobs[i].DependsOn))
+    for _, dep := range req.Jobs[i].DependsOn {
+      jobReq.DependsOn = append(jobReq.DependsOn, models.Dependency{ID: ids[dep.ID], OnFailure: dep.OnFailure})
+    }
+
+    job, err := newJob(ids[req.Jobs[i].Key], &jobReq)
+    if err != nil {
+      return nil, errors.Wrapf(err, "job %s", req.Jobs[i].Key)
+    }
+    job.WorkflowID = workflowID
+    jobs = append(jobs, job)
+  }
+  for _, job := range req.Jobs {
+    workflow.Jobs = append(workflow.Jobs, models.WorkflowJob{Key: job.Key, ID: ids[job.Key], Name: job.Name})
+  }
+
+  if err = svc.repo.AddWorkflow(ctx, workflow, jobs); err != nil {
+    return nil, err
+  }
+
+  log.Info().Str("workflow_id", workflowID).Int("jobs", len(jobs)).Msg("workflow created")
+  return workflow, nil
+}
+
+// GetWorkflow возвращает workflow со статусами джоб и общим статусом: completed - все джобы завершились успешно,
+// failed - какая-то джоба упала, отменена или истекла и выполнять больше нечего, pending - ни одна джоба ещё
+// не начата
+func (svc *JobService) GetWorkflow(ctx context.Context, workflowID string) (*models.Workflow, error) {
+  workflow, err := svc.repo.GetWorkflow(ctx, workflowID)
+  if err != nil {
+    return nil, err
+  }
+
+  workflow.Counts = make(map[string]int)
+  for i := range workflow.Jobs {
+    // джоба удалена из redis, например при очистке dead letter queue
+    if workflow.Jobs[i].Status == "" {
+      workflow.Jobs[i].Status = "missing"
+    }
+    workflow.Counts[workflow.Jobs[i].Status]++
+  }
+
+  unfinished := len(workflow.Jobs) - workflow.Counts["completed"] - workflow.Counts["failed"] -
+    workflow.Counts["cancelled"] - workflow.Counts["expired"] - workflow.Counts["missing"]
+  switch {
+  case workflow.Counts["completed"] == len(workflow.Jobs):
+    workflow.Status = models.WorkflowCompleted
+  case unfinished == 0:
+    workflow.Status = models.WorkflowFailed
+  case unfinished == workflow.Counts["waiting"]+workflow.Counts["pending"]:
+    workflow.Status = models.WorkflowPending
+  default:
+    workflow.Status = models.WorkflowRunning
+  }
+
+  return workflow, nil
+}
+
+// workflowOrder проверяет граф и возвращает индексы джоб в топологическом порядке
+func workflowOrder(jobs []models.WorkflowJobRequest) ([]int, error) {
+  if len(jobs) == 0 {
+    return nil, errors.Wrap(ErrInvalidWorkflow, "no jobs")
+  }
+
+  index := make(map[string]int, len(jobs))
+  for i, job := range jobs {
+    if job.Key == "" {
+      return nil, errors.Wrapf(ErrInvalidWorkflow, "job %d has no key", i)
+    }
+    if _, ok := index[job.Key]; ok {
+      return nil, errors.Wrapf(ErrInvalidWorkflow, "duplicate key %s", job.Key)
+    }
+    index[job.Key] = i
+  }
+
+  pending := make([]int, len(jobs))
+  children := make([][]int, len(jobs))
+  for i, job := range jobs {
+    for _, dep := range job.DependsOn {
+      parent, ok := index[dep.ID]
+      if !ok {
+        return nil, errors.Wrapf(ErrInvalidWorkflow, "job %s depends on unknown key %s", job.Key, dep.ID)
+      }
+      children[parent] = append(children[parent], i)
+      pending[i]++
+    }
+  }
+
+  order := make([]int, 0, len(jobs))
+  for i := range jobs {
+    if pending[i] == 0 {
+      order = append(order, i)
+    }
+  }
+  for next := 0; next < len(order); next++ {
+    for _, child := range children[order[next]] {
+      pending[child]--
+      if pending[child] == 0 {
+        order = append(order, child)
+      }
+    }
+  }
+  if len(order) != len(jobs) {
+    return nil, errors.Wrap(ErrInvalidWorkflow, "dependency cycle")
+  }
+
+  return order, nil
+}
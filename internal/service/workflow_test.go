@@ -0,0 +1,76 @@
+package service
+
+import (
+  "testing"
+
+  "flussonic_tz/models"
+
+  "github.com/pkg/errors"
+)
+
+func workflowJob(key string, deps ...string) models.WorkflowJobRequest {
+  job := models.WorkflowJobRequest{Key: key}
+  for _, dep := range deps {
+    job.DependsOn = append(job.DependsOn, models.Dependency{ID: dep})
+  }
+  return job
+}
+
+func TestWorkflowOrder(t *testing.T) {
+  tests := []struct {
+    name    string
+    jobs    []models.WorkflowJobRequest
+    wantErr bool
+  }{
+    {name: "empty", wantErr: true},
+    {name: "single", jobs: []models.WorkflowJobRequest{workflowJob("a")}},
+    {name: "chain in reverse order", jobs: []models.WorkflowJobRequest{
+      workflowJob("c", "b"), workflowJob("b", "a"), workflowJob("a"),
+    }},
+    {name: "diamond", jobs: []models.WorkflowJobRequest{
+      workflowJob("d", "b", "c"), workflowJob("b", "a"), workflowJob("c", "a"), workflowJob("a"),
+    }},
+    {name: "independent", jobs: []models.WorkflowJobRequest{workflowJob("a"), workflowJob("b")}},
+    {name: "missing key", jobs: []models.WorkflowJobRequest{workflowJob("a"), workflowJob("")}, wantErr: true},
+    {name: "duplicate key", jobs: []models.WorkflowJobRequest{workflowJob("a"), workflowJob("a")}, wantErr: true},
+    {name: "unknown key", jobs: []models.WorkflowJobRequest{workflowJob("a", "x")}, wantErr: true},
+    {name: "self dependency", jobs: []models.WorkflowJobRequest{workflowJob("a", "a")}, wantErr: true},
+    {name: "cycle", jobs: []models.WorkflowJobRequest{
+      workflowJob("root"), workflowJob("a", "root", "c"), workflowJob("b", "a"), workflowJob("c", "b"),
+    }, wantErr: true},
+  }
+
+  for _, tt := range tests {
+    t.Run(tt.name, func(t *testing.T) {
+      order, err := workflowOrder(tt.jobs)
+      if tt.wantErr {
+        if !errors.Is(err, ErrInvalidWorkflow) {
+          t.Fatalf("expected ErrInvalidWorkflow, got %v", err)
+        }
+        return
+      }
+      if err != nil {
+        t.Fatalf("unexpected error: %v", err)
+      }
+      if len(order) != len(tt.jobs) {
+        t.Fatalf("order %v doesn't cover %d jobs", order, len(tt.jobs))
+      }
+
+      // каждая джоба идёт после всех своих родителей
+      position := make(map[string]int, len(order))
+      for pos, i := range order {
+        if _, ok := position[tt.jobs[i].Key]; ok {
+          t.Fatalf("job %s appears twice in %v", tt.jobs[i].Key, order)
+        }
+        position[tt.jobs[i].Key] = pos
+      }
+      for _, job := range tt.jobs {
+        for _, dep := range job.DependsOn {
+          if position[dep.ID] >= position[job.Key] {
+            t.Errorf("job %s is ordered before its parent %s: %v", job.Key, dep.ID, order)
+          }
+        }
+      }
+    })
+  }
+}
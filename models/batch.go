@@ -0,0 +1,28 @@
+package models
+
+import "time"
+
+const (
+  BatchRunning   = "running"
+  BatchCompleted = "completed"
+)
+
+// BatchRequest - пачка независимых джоб. callback ставится в очередь, когда завершатся все джобы пачки,
+// неважно успешно или нет
+type BatchRequest struct {
+  Jobs     []JobRequest `json:"jobs" validate:"required"`
+  Callback *JobRequest  `json:"callback,omitempty"`
+}
+
+type Batch struct {
+  ID         string    `json:"id"`
+  Status     string    `json:"status"`
+  Total      int       `json:"total"`
+  Pending    int       `json:"pending"`
+  Succeeded  int       `json:"succeeded"`
+  Failed     int       `json:"failed"`
+  Cancelled  int       `json:"cancelled"`
+  Expired    int       `json:"expired"`
+  CallbackID string    `json:"callback_id,omitempty"`
+  CreatedAt  time.Time `json:"created_at"`
+}
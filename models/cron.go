@@ -0,0 +1,13 @@
+package models
+
+import "time"
+
+type CronEntry struct {
+  Name     string      `json:"name"`
+  Schedule string      `json:"schedule"`
+  Timezone string      `json:"timezone"`
+  Score    float64     `json:"score"`
+  Missed   string      `json:"missed"`
+  LastTick *time.Time  `json:"last_tick,omitempty"`
+  Upcoming []time.Time `json:"upcoming"`
+}
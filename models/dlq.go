@@ -0,0 +1,36 @@
+package models
+
+import (
+  "encoding/json"
+  "time"
+)
+
+type AttemptRecord struct {
+  Attempt   int       `json:"attempt"`
+  Error     string    `json:"error"`
+  StartedAt time.Time `json:"started_at"`
+  FailedAt  time.Time `json:"failed_at"`
+}
+
+type DeadJob struct {
+  ID       string          `json:"id"`
+  Name     string          `json:"name"`
+  Error    string          `json:"error"`
+  Attempts int             `json:"attempts"`
+  DeadAt   time.Time       `json:"dead_at"`
+  Payload  json.RawMessage `json:"payload,omitempty"`
+  History  []AttemptRecord `json:"history,omitempty"`
+}
+
+// ReplayRejection - джоба, которую нельзя перезапустить сейчас, она остаётся в dead letter queue
+type ReplayRejection struct {
+  ID     string `json:"id"`
+  Reason string `json:"reason"`
+}
+
+type DeadJobsPage struct {
+  Total  int64     `json:"total"`
+  Offset int       `json:"offset"`
+  Limit  int       `json:"limit"`
+  Jobs   []DeadJob `json:"jobs"`
+}
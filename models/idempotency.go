@@ -0,0 +1,11 @@
+package models
+
+import "time"
+
+// IdempotencyRecord - ответ на POST /jobs, сохранённый под Idempotency-Key. пока джоба создаётся, JobID пустой
+type IdempotencyRecord struct {
+  Fingerprint string    `json:"fingerprint"`
+  JobID       string    `json:"job_id,omitempty"`
+  Status      string    `json:"status,omitempty"`
+  CreatedAt   time.Time `json:"created_at"`
+}
@@ -1,18 +1,96 @@
 package models
 
-import "time"
+import (
+  "encoding/json"
+  "time"
+)
 
 type Job struct {
-  ID         string    `json:"id"`
-  Name       string    `json:"name"`
-  Score      float64   `json:"score"`
-  Status     string    `json:"status"`
+  ID      string          `json:"id"`
+  Name    string          `json:"name"`
+  Score   float64         `json:"score"`
+  Status  string          `json:"status"`
+  Payload json.RawMessage `json:"payload,omitempty"`
+  Retry   *RetryPolicy    `json:"retry,omitempty"`
+  Attempt int             `json:"attempt"`
+
+  MaxRedeliveries int `json:"max_redeliveries,omitempty"`
+  Redeliveries    int `json:"redeliveries,omitempty"`
+  // номер выдачи джобы воркеру, по нему отличаются обработчики разных выдач одной и той же попытки
+  Delivery int `json:"delivery,omitempty"`
+
+  DependsOn  []Dependency `json:"depends_on,omitempty"`
+  WorkflowID string       `json:"workflow_id,omitempty"`
+  BatchID    string       `json:"batch_id,omitempty"`
+
+  UniqueKey   string        `json:"unique_key,omitempty"`
+  UniqueScope string        `json:"unique_scope,omitempty"`
+  UniqueTTL   time.Duration `json:"unique_ttl,omitempty"`
+
   CreatedAt  time.Time `json:"created_at"`
+  RunAt      time.Time `json:"run_at,omitempty"`
+  ExpiresAt  time.Time `json:"expires_at,omitempty"`
   StartedAt  time.Time `json:"started_at"`
   FinishedAt time.Time `json:"finished_at"`
 }
 
 type JobRequest struct {
-  Name  string  `json:"name" validate:"required"`
-  Score float64 `json:"score" validate:"required"`
+  Name    string          `json:"name" validate:"required"`
+  Score   float64         `json:"score" validate:"required"`
+  Payload json.RawMessage `json:"payload,omitempty"`
+  Retry   *RetryPolicy    `json:"retry,omitempty"`
+
+  MaxRedeliveries int `json:"max_redeliveries,omitempty"`
+
+  // джоба попадёт в очередь не раньше run_at или через delay после создания, задаётся что-то одно
+  RunAt *time.Time `json:"run_at,omitempty"`
+  Delay Duration   `json:"delay,omitempty"`
+
+  // джоба попадёт в очередь только после успешного завершения всех джоб из depends_on, до этого она в статусе waiting
+  DependsOn []Dependency `json:"depends_on,omitempty"`
+
+  // пока жива джоба с тем же unique_key, новая не создаётся, вместо неё возвращается id существующей.
+  // unique_scope: pending - пока джобу не взяли в работу, active (по умолчанию) - пока она не завершилась,
+  // ttl - в течение unique_ttl после создания
+  UniqueKey   string   `json:"unique_key,omitempty"`
+  UniqueScope string   `json:"unique_scope,omitempty"`
+  UniqueTTL   Duration `json:"unique_ttl,omitempty"`
+
+  // если джобу не взяли в работу до expires_at или в течение ttl после создания, она не выполняется и получает
+  // статус expired, задаётся что-то одно
+  ExpiresAt *time.Time `json:"expires_at,omitempty"`
+  TTL       Duration   `json:"ttl,omitempty"`
+}
+
+const (
+  UniqueScopePending = "pending"
+  UniqueScopeActive  = "active"
+  UniqueScopeTTL     = "ttl"
+)
+
+// Duration в JSON передаётся строкой вида "15m"
+type Duration time.Duration
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+  return json.Marshal(time.Duration(d).String())
+}
+
+func (d *Duration) UnmarshalJSON(data []byte) error {
+  var value string
+  if err := json.Unmarshal(data, &value); err != nil {
+    return err
+  }
+
+  return d.UnmarshalText([]byte(value))
+}
+
+// UnmarshalText нужен, чтобы Duration так же читался из конфига
+func (d *Duration) UnmarshalText(text []byte) error {
+  duration, err := time.ParseDuration(string(text))
+  if err != nil {
+    return err
+  }
+
+  *d = Duration(duration)
+  return nil
 }
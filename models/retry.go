@@ -0,0 +1,34 @@
+package models
+
+type RetryPolicy struct {
+  MaxAttempts  int        `json:"max_attempts,omitempty" yaml:"max_attempts" mapstructure:"max_attempts"`
+  InitialDelay Duration   `json:"initial_delay,omitempty" yaml:"initial_delay" mapstructure:"initial_delay"`
+  Multiplier   float64    `json:"multiplier,omitempty" yaml:"multiplier" mapstructure:"multiplier"`
+  MaxDelay     Duration   `json:"max_delay,omitempty" yaml:"max_delay" mapstructure:"max_delay"`
+  Jitter       float64    `json:"jitter,omitempty" yaml:"jitter" mapstructure:"jitter"`
+  Schedule     []Duration `json:"schedule,omitempty" yaml:"schedule" mapstructure:"schedule"`
+}
+
+// Merge дополняет незаданные поля политики значениями из base
+func (p RetryPolicy) Merge(base RetryPolicy) RetryPolicy {
+  if p.MaxAttempts == 0 {
+    p.MaxAttempts = base.MaxAttempts
+  }
+  if p.InitialDelay == 0 {
+    p.InitialDelay = base.InitialDelay
+  }
+  if p.Multiplier == 0 {
+    p.Multiplier = base.Multiplier
+  }
+  if p.MaxDelay == 0 {
+    p.MaxDelay = base.MaxDelay
+  }
+  if p.Jitter == 0 {
+    p.Jitter = base.Jitter
+  }
+  if len(p.Schedule) == 0 {
+    p.Schedule = base.Schedule
+  }
+
+  return p
+}
@@ -0,0 +1,67 @@
+package models
+
+import "time"
+
+type StuckJob struct {
+  ID          string    `json:"id"`
+  Name        string    `json:"name"`
+  CancelledAt time.Time `json:"cancelled_at"`
+}
+
+type PoolStats struct {
+  Workers   int            `json:"workers"`
+  Paused    bool           `json:"paused"`
+  Failures  int64          `json:"failures"`
+  Panics    int64          `json:"panics"`
+  RateLimit RateLimitStats `json:"rate_limit"`
+}
+
+type RateLimitStats struct {
+  Algorithm string `json:"algorithm"`
+  Limit     int    `json:"limit"`
+  Interval  string `json:"interval"`
+  Remaining int    `json:"remaining"`
+}
+
+type DrainSummary struct {
+  InFlight int `json:"in_flight"`
+  Drained  int `json:"drained"`
+  Requeued int `json:"requeued"`
+  // джобы, которые не удалось вернуть в очередь, они остаются в работе до истечения lease
+  InterruptFailed int `json:"interrupt_failed"`
+}
+
+type ScalingDecision struct {
+  At         time.Time `json:"at"`
+  From       int       `json:"from"`
+  To         int       `json:"to"`
+  QueueDepth int64     `json:"queue_depth"`
+  Latency    string    `json:"latency"`
+  Reason     string    `json:"reason"`
+}
+
+type AutoscalerStats struct {
+  Enabled    bool              `json:"enabled"`
+  MinWorkers int               `json:"min_workers"`
+  MaxWorkers int               `json:"max_workers"`
+  Workers    int               `json:"workers"`
+  QueueDepth int64             `json:"queue_depth"`
+  Latency    string            `json:"latency"`
+  Desired    int               `json:"desired"`
+  Decisions  []ScalingDecision `json:"decisions"`
+}
+
+type Instance struct {
+  ID          string        `json:"id"`
+  Host        string        `json:"host"`
+  StartedAt   time.Time     `json:"started_at"`
+  HeartbeatAt time.Time     `json:"heartbeat_at"`
+  Workers     []WorkerState `json:"workers"`
+}
+
+type WorkerState struct {
+  ID           int        `json:"id"`
+  JobID        string     `json:"job_id,omitempty"`
+  JobName      string     `json:"job_name,omitempty"`
+  JobStartedAt *time.Time `json:"job_started_at,omitempty"`
+}
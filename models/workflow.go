@@ -0,0 +1,62 @@
+package models
+
+import (
+  "encoding/json"
+  "time"
+)
+
+const (
+  OnFailureCancel = "cancel"
+  OnFailureFail   = "fail"
+)
+
+const (
+  WorkflowPending   = "pending"
+  WorkflowRunning   = "running"
+  WorkflowCompleted = "completed"
+  WorkflowFailed    = "failed"
+)
+
+// Dependency - ребро графа: джоба ждёт успешного завершения родителя ID. если родитель упал или отменён,
+// зависимая джоба отменяется (cancel) или падает (fail)
+type Dependency struct {
+  ID        string `json:"id"`
+  OnFailure string `json:"on_failure,omitempty"`
+}
+
+// UnmarshalJSON разрешает передавать зависимость просто строкой с id, политика тогда берётся по умолчанию
+func (d *Dependency) UnmarshalJSON(data []byte) error {
+  var id string
+  if err := json.Unmarshal(data, &id); err == nil {
+    *d = Dependency{ID: id}
+    return nil
+  }
+
+  type dependency Dependency
+  return json.Unmarshal(data, (*dependency)(d))
+}
+
+// WorkflowJobRequest - джоба внутри workflow. depends_on ссылается на key других джоб этого же workflow
+type WorkflowJobRequest struct {
+  Key string `json:"key" validate:"required"`
+  JobRequest
+}
+
+type WorkflowRequest struct {
+  Jobs []WorkflowJobRequest `json:"jobs" validate:"required"`
+}
+
+type WorkflowJob struct {
+  Key    string `json:"key"`
+  ID     string `json:"id"`
+  Name   string `json:"name,omitempty"`
+  Status string `json:"status,omitempty"`
+}
+
+type Workflow struct {
+  ID        string         `json:"id"`
+  Status    string         `json:"status,omitempty"`
+  CreatedAt time.Time      `json:"created_at"`
+  Counts    map[string]int `json:"counts,omitempty"`
+  Jobs      []WorkflowJob  `json:"jobs"`
+}
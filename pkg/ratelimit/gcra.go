@@ -0,0 +1,49 @@
+package ratelimit
+
+import (
+  "time"
+)
+
+// gcra - generic cell rate algorithm, вместо счётчика токенов хранит теоретическое время следующего события
+type gcra struct {
+  emission  time.Duration
+  tolerance time.Duration
+  tat       time.Time
+}
+
+func newGCRA(burst int, emission time.Duration) *gcra {
+  return &gcra{
+    emission:  emission,
+    tolerance: time.Duration(burst-1) * emission,
+  }
+}
+
+func (g *gcra) take(now time.Time) time.Duration {
+  tat := g.tat
+  if tat.Before(now) {
+    tat = now
+  }
+
+  if delay := tat.Sub(now) - g.tolerance; delay > 0 {
+    return delay
+  }
+
+  g.tat = tat.Add(g.emission)
+  return 0
+}
+
+func (g *gcra) release(now time.Time) {
+  g.tat = g.tat.Add(-g.emission)
+  if g.tat.Before(now) {
+    g.tat = now
+  }
+}
+
+func (g *gcra) remaining(now time.Time) int {
+  tat := g.tat
+  if tat.Before(now) {
+    tat = now
+  }
+
+  return int((g.tolerance - tat.Sub(now) + g.emission) / g.emission)
+}
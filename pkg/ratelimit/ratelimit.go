@@ -0,0 +1,97 @@
+package ratelimit
+
+import (
+  "context"
+  "sync"
+  "time"
+
+  errs "flussonic_tz/internal/errors"
+
+  "github.com/pkg/errors"
+)
+
+const (
+  TokenBucket   = "token_bucket"
+  SlidingWindow = "sliding_window"
+  GCRA          = "gcra"
+)
+
+type Limiter interface {
+  // Wait блокируется до появления свободного слота и занимает его
+  Wait(ctx context.Context) error
+  // Release возвращает слот, если он оказался не нужен (например, очередь была пуста)
+  Release()
+  Remaining() int
+}
+
+type algorithm interface {
+  take(now time.Time) time.Duration
+  release(now time.Time)
+  remaining(now time.Time) int
+}
+
+type limiter struct {
+  mu   sync.Mutex
+  algo algorithm
+}
+
+// New создаёт лимитер, который пропускает не больше limit событий в любом скользящем окне длиной interval.
+// burst задаёт, сколько событий можно выполнить подряд без ожидания (для sliding_window не используется)
+func New(name string, limit int, interval time.Duration, burst int) (Limiter, error) {
+  if limit <= 0 || interval <= 0 {
+    return nil, errors.Errorf("%s: limit=%d interval=%s", errs.ErrInvalidRateLimit, limit, interval)
+  }
+
+  burst = max(1, min(burst, limit))
+  // скорость пополнения выбрана так, чтобы burst + пополнение за interval не превышали limit. деление округляется
+  // вверх: при округлении вниз за interval успевал бы пополниться лишний слот
+  steps := time.Duration(limit - burst + 1)
+  emission := (interval + steps - 1) / steps
+
+  var algo algorithm
+  switch name {
+  case TokenBucket:
+    algo = newTokenBucket(burst, emission)
+  case SlidingWindow:
+    algo = newSlidingWindow(limit, interval)
+  case GCRA:
+    algo = newGCRA(burst, emission)
+  default:
+    return nil, errors.Errorf("%s: %s", errs.ErrUnknownRateAlgorithm, name)
+  }
+
+  return &limiter{algo: algo}, nil
+}
+
+func (l *limiter) Wait(ctx context.Context) error {
+  for {
+    l.mu.Lock()
+    delay := l.algo.take(time.Now())
+    l.mu.Unlock()
+
+    if delay <= 0 {
+      return nil
+    }
+
+    timer := time.NewTimer(delay)
+    select {
+    case <-timer.C:
+    case <-ctx.Done():
+      timer.Stop()
+      return ctx.Err()
+    }
+  }
+}
+
+func (l *limiter) Release() {
+  l.mu.Lock()
+  l.algo.release(time.Now())
+  l.mu.Unlock()
+}
+
+func (l *limiter) Remaining() int {
+  l.mu.Lock()
+  defer l.mu.Unlock()
+
+  return l.algo.remaining(time.Now())
+}
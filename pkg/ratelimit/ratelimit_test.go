@@ -0,0 +1,141 @@
+package ratelimit
+
+import (
+  "fmt"
+  "testing"
+  "time"
+)
+
+// simulate забирает слоты как можно раньше на протяжении windows окон и возвращает моменты событий
+func simulate(t *testing.T, algo algorithm, start time.Time, interval time.Duration, windows int) []time.Time {
+  t.Helper()
+
+  var events []time.Time
+  end := start.Add(time.Duration(windows) * interval)
+  for now, steps := start, 0; now.Before(end); steps++ {
+    if steps > 1_000_000 {
+      t.Fatal("limiter doesn't make progress")
+    }
+    if delay := algo.take(now); delay > 0 {
+      now = now.Add(delay)
+      continue
+    }
+    events = append(events, now)
+  }
+  return events
+}
+
+func TestLimitPerInterval(t *testing.T) {
+  configs := []struct {
+    limit    int
+    interval time.Duration
+    burst    int
+  }{
+    {limit: 100, interval: time.Minute, burst: 10},
+    {limit: 5, interval: time.Second, burst: 1},
+    {limit: 3, interval: 10 * time.Second, burst: 3},
+    {limit: 7, interval: time.Second, burst: 2},
+    {limit: 10, interval: time.Second, burst: 100},
+  }
+
+  for _, name := range []string{TokenBucket, SlidingWindow, GCRA} {
+    for _, cfg := range configs {
+      t.Run(fmt.Sprintf("%s/%d per %s burst %d", name, cfg.limit, cfg.interval, cfg.burst), func(t *testing.T) {
+        l, err := New(name, cfg.limit, cfg.interval, cfg.burst)
+        if err != nil {
+          t.Fatal(err)
+        }
+        start := time.Now()
+        events := simulate(t, l.(*limiter).algo, start, cfg.interval, 3)
+
+        // в любом окне (t - interval, t] не больше limit событий
+        for i := range events {
+          inWindow := 0
+          for j := i; j >= 0 && events[j].After(events[i].Add(-cfg.interval)); j-- {
+            inWindow++
+          }
+          if inWindow > cfg.limit {
+            t.Fatalf("%d events in window ending at +%s, limit %d", inWindow, events[i].Sub(start), cfg.limit)
+          }
+        }
+
+        // и лимит при этом выбирается полностью
+        first := 0
+        for _, event := range events {
+          if event.Before(start.Add(cfg.interval)) {
+            first++
+          }
+        }
+        if first != cfg.limit {
+          t.Errorf("%d events in the first interval, want %d", first, cfg.limit)
+        }
+      })
+    }
+  }
+}
+
+func TestBurst(t *testing.T) {
+  for _, name := range []string{TokenBucket, GCRA} {
+    t.Run(name, func(t *testing.T) {
+      l, err := New(name, 100, time.Minute, 10)
+      if err != nil {
+        t.Fatal(err)
+      }
+      algo := l.(*limiter).algo
+      now := time.Now()
+      for i := 0; i < 10; i++ {
+        if delay := algo.take(now); delay > 0 {
+          t.Fatalf("event %d of burst delayed by %s", i, delay)
+        }
+      }
+      if delay := algo.take(now); delay <= 0 {
+        t.Fatal("event after burst isn't delayed")
+      }
+    })
+  }
+}
+
+func TestReleaseAndRemaining(t *testing.T) {
+  for _, name := range []string{TokenBucket, SlidingWindow, GCRA} {
+    t.Run(name, func(t *testing.T) {
+      l, err := New(name, 3, time.Hour, 3)
+      if err != nil {
+        t.Fatal(err)
+      }
+      algo := l.(*limiter).algo
+      now := time.Now()
+
+      if got := algo.remaining(now); got != 3 {
+        t.Fatalf("remaining %d, want 3", got)
+      }
+      for i := 0; i < 3; i++ {
+        if delay := algo.take(now); delay > 0 {
+          t.Fatalf("take %d delayed by %s", i, delay)
+        }
+      }
+      if got := algo.remaining(now); got != 0 {
+        t.Fatalf("remaining %d after taking all slots, want 0", got)
+      }
+
+      algo.release(now)
+      if got := algo.remaining(now); got != 1 {
+        t.Fatalf("remaining %d after release, want 1", got)
+      }
+      if delay := algo.take(now); delay > 0 {
+        t.Fatalf("released slot not reused, delayed by %s", delay)
+      }
+    })
+  }
+}
+
+func TestNewValidation(t *testing.T) {
+  if _, err := New(TokenBucket, 0, time.Second, 1); err == nil {
+    t.Error("zero limit accepted")
+  }
+  if _, err := New(TokenBucket, 1, 0, 1); err == nil {
+    t.Error("zero interval accepted")
+  }
+  if _, err := New("leaky_bucket", 1, time.Second, 1); err == nil {
+    t.Error("unknown algorithm accepted")
+  }
+}
@@ -0,0 +1,50 @@
+package ratelimit
+
+import (
+  "time"
+)
+
+// slidingWindow хранит время последних limit событий, поэтому ограничение точное для любого окна
+type slidingWindow struct {
+  interval time.Duration
+  events   []time.Time
+  limit    int
+}
+
+func newSlidingWindow(limit int, interval time.Duration) *slidingWindow {
+  return &slidingWindow{
+    interval: interval,
+    events:   make([]time.Time, 0, limit),
+    limit:    limit,
+  }
+}
+
+func (w *slidingWindow) evict(now time.Time) {
+  border := now.Add(-w.interval)
+  i := 0
+  for i < len(w.events) && !w.events[i].After(border) {
+    i++
+  }
+  w.events = append(w.events[:0], w.events[i:]...)
+}
+
+func (w *slidingWindow) take(now time.Time) time.Duration {
+  w.evict(now)
+  if len(w.events) < w.limit {
+    w.events = append(w.events, now)
+    return 0
+  }
+
+  return w.events[0].Add(w.interval).Sub(now)
+}
+
+func (w *slidingWindow) release(_ time.Time) {
+  if len(w.events) > 0 {
+    w.events = w.events[:len(w.events)-1]
+  }
+}
+
+func (w *slidingWindow) remaining(now time.Time) int {
+  w.evict(now)
+  return w.limit - len(w.events)
+}
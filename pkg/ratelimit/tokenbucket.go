@@ -0,0 +1,48 @@
+package ratelimit
+
+import (
+  "time"
+)
+
+type tokenBucket struct {
+  capacity float64
+  tokens   float64
+  emission time.Duration
+  last     time.Time
+}
+
+func newTokenBucket(capacity int, emission time.Duration) *tokenBucket {
+  return &tokenBucket{
+    capacity: float64(capacity),
+    tokens:   float64(capacity),
+    emission: emission,
+    last:     time.Now(),
+  }
+}
+
+func (b *tokenBucket) refill(now time.Time) {
+  if now.After(b.last) {
+    b.tokens = min(b.capacity, b.tokens+float64(now.Sub(b.last))/float64(b.emission))
+    b.last = now
+  }
+}
+
+func (b *tokenBucket) take(now time.Time) time.Duration {
+  b.refill(now)
+  if b.tokens >= 1 {
+    b.tokens--
+    return 0
+  }
+
+  return time.Duration((1 - b.tokens) * float64(b.emission))
+}
+
+func (b *tokenBucket) release(now time.Time) {
+  b.refill(now)
+  b.tokens = min(b.capacity, b.tokens+1)
+}
+
+func (b *tokenBucket) remaining(now time.Time) int {
+  b.refill(now)
+  return int(b.tokens)
+}
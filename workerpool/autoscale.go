@@ -0,0 +1,188 @@
+package workerpool
+
+import (
+  "context"
+  "fmt"
+  "math"
+  "sync"
+  "time"
+
+  "flussonic_tz/config"
+  errs "flussonic_tz/internal/errors"
+  "flussonic_tz/models"
+
+  "github.com/pkg/errors"
+  "github.com/rs/zerolog/log"
+)
+
+const (
+  // вес нового замера в скользящем среднем длительности джоб
+  LatencySmoothing = 0.2
+  // сколько последних решений автоскейлера хранится для /workerpool/autoscaler
+  MaxScalingDecisions = 50
+)
+
+type autoscaler struct {
+  mu        sync.Mutex
+  latency   time.Duration
+  depth     int64
+  desired   int
+  lastScale time.Time
+  decisions []models.ScalingDecision
+
+  // direction - куда указывают замеры подряд начиная с streakStart, target - самое осторожное из их значений
+  direction   int
+  streakStart time.Time
+  target      int
+}
+
+func validateAutoscale(cfg *config.WorkerPool) error {
+  as := cfg.Autoscale
+  if !as.Enabled {
+    return nil
+  }
+  if as.MinWorkers < 1 || as.MaxWorkers < as.MinWorkers || as.MaxWorkers > cfg.MaxWorkers {
+    return errors.Errorf("%s: workers bounds [%d, %d] not in [1, %d]",
+      errs.ErrInvalidAutoscale, as.MinWorkers, as.MaxWorkers, cfg.MaxWorkers)
+  }
+  if as.Interval <= 0 || as.TargetDrain <= 0 {
+    return errors.Errorf("%s: interval and target_drain must be positive", errs.ErrInvalidAutoscale)
+  }
+
+  return nil
+}
+
+// observeLatency учитывает длительность выполнения джобы в скользящем среднем
+func (wp *WorkerPool) observeLatency(d time.Duration) {
+  wp.scaler.mu.Lock()
+  defer wp.scaler.mu.Unlock()
+
+  if wp.scaler.latency == 0 {
+    wp.scaler.latency = d
+    return
+  }
+  wp.scaler.latency = time.Duration(float64(wp.scaler.latency)*(1-LatencySmoothing) + float64(d)*LatencySmoothing)
+}
+
+// autoscale периодически подбирает количество воркеров по длине очереди и длительности джоб
+func (wp *WorkerPool) autoscale(ctx context.Context) {
+  defer wp.wg.Done()
+
+  ticker := time.NewTicker(wp.cfg.Autoscale.Interval)
+  defer ticker.Stop()
+
+  for {
+    select {
+    case <-wp.done:
+      return
+    case now := <-ticker.C:
+      wp.scale(ctx, now)
+    }
+  }
+}
+
+func (wp *WorkerPool) scale(ctx context.Context, now time.Time) {
+  depth, err := wp.repo.QueueDepth(ctx)
+  if err != nil {
+    wrapped := errors.Wrap(err, errs.ErrAutoscale)
+    log.Error().Err(wrapped).Msg(wrapped.Error())
+    return
+  }
+
+  s := &wp.scaler
+  s.mu.Lock()
+  defer s.mu.Unlock()
+
+  latency := s.latency
+  // пока ни одна джоба не выполнилась, считаем, что каждая идёт до таймаута
+  if latency == 0 {
+    latency = wp.cfg.Timeout
+  }
+  current := wp.Workers()
+  desired := wp.desiredWorkers(depth, latency)
+  s.depth, s.desired = depth, desired
+
+  // hysteresis: замеры должны указывать в одну сторону всё окно, иначе отсчёт начинается заново
+  direction := sign(desired - current)
+  switch {
+  case direction != s.direction:
+    s.direction, s.streakStart, s.target = direction, now, desired
+  case direction > 0:
+    s.target = min(s.target, desired)
+  case direction < 0:
+    s.target = max(s.target, desired)
+  }
+  if direction == 0 || now.Sub(s.streakStart) < wp.cfg.Autoscale.HysteresisWindow {
+    return
+  }
+
+  cooldown := wp.cfg.Autoscale.ScaleUpCooldown
+  if direction < 0 {
+    cooldown = wp.cfg.Autoscale.ScaleDownCooldown
+  }
+  if now.Sub(s.lastScale) < cooldown {
+    return
+  }
+
+  if err = wp.Resize(s.target); err != nil {
+    wrapped := errors.Wrap(err, errs.ErrAutoscale)
+    log.Error().Err(wrapped).Msg(wrapped.Error())
+    return
+  }
+
+  decision := models.ScalingDecision{
+    At:         now,
+    From:       current,
+    To:         s.target,
+    QueueDepth: depth,
+    Latency:    latency.String(),
+    Reason: fmt.Sprintf("%d jobs of %s, %d workers drain them in %s",
+      depth, latency, s.target, wp.cfg.Autoscale.TargetDrain),
+  }
+  s.decisions = append(s.decisions, decision)
+  if len(s.decisions) > MaxScalingDecisions {
+    s.decisions = s.decisions[len(s.decisions)-MaxScalingDecisions:]
+  }
+  s.lastScale = now
+  s.direction = 0
+
+  log.Info().
+    Int("from", decision.From).
+    Int("to", decision.To).
+    Int64("queue_depth", depth).
+    Dur("latency", latency).
+    Msg("autoscaler decision")
+}
+
+// desiredWorkers - сколько воркеров нужно, чтобы разобрать очередь за target_drain
+func (wp *WorkerPool) desiredWorkers(depth int64, latency time.Duration) int {
+  need := int(math.Ceil(float64(depth) * float64(latency) / float64(wp.cfg.Autoscale.TargetDrain)))
+
+  return min(max(need, wp.cfg.Autoscale.MinWorkers), wp.cfg.Autoscale.MaxWorkers)
+}
+
+func (wp *WorkerPool) Autoscaler() models.AutoscalerStats {
+  wp.scaler.mu.Lock()
+  defer wp.scaler.mu.Unlock()
+
+  return models.AutoscalerStats{
+    Enabled:    wp.cfg.Autoscale.Enabled,
+    MinWorkers: wp.cfg.Autoscale.MinWorkers,
+    MaxWorkers: wp.cfg.Autoscale.MaxWorkers,
+    Workers:    wp.Workers(),
+    QueueDepth: wp.scaler.depth,
+    Latency:    wp.scaler.latency.String(),
+    Desired:    wp.scaler.desired,
+    Decisions:  append([]models.ScalingDecision{}, wp.scaler.decisions...),
+  }
+}
+
+func sign(n int) int {
+  switch {
+  case n > 0:
+    return 1
+  case n < 0:
+    return -1
+  }
+  return 0
+}
@@ -0,0 +1,138 @@
+package workerpool
+
+import (
+  "context"
+  "testing"
+  "time"
+
+  "flussonic_tz/config"
+  "flussonic_tz/internal/service"
+  "flussonic_tz/models"
+  "flussonic_tz/pkg/ratelimit"
+)
+
+// idleRepo отдаёт заданную длину очереди, а воркеры на нём простаивают до остановки
+type idleRepo struct {
+  service.JobRepository
+  depth int64
+}
+
+func (r *idleRepo) QueueDepth(_ context.Context) (int64, error) {
+  return r.depth, nil
+}
+
+func (r *idleRepo) GetJob(_ context.Context, _ time.Duration) (*models.Job, error) {
+  return nil, service.ErrQueueEmpty
+}
+
+func (r *idleRepo) WaitForJob(ctx context.Context, _ time.Duration) (bool, error) {
+  <-ctx.Done()
+  return false, ctx.Err()
+}
+
+func newTestAutoscaler(t *testing.T) (*WorkerPool, *idleRepo) {
+  t.Helper()
+
+  repo := &idleRepo{}
+  cfg := &config.WorkerPool{
+    MaxWorkers:    20,
+    Timeout:       time.Minute,
+    JobLimit:      1000,
+    JobInterval:   time.Second,
+    RateAlgorithm: ratelimit.TokenBucket,
+    IdleBackoff:   time.Second,
+    Autoscale: config.Autoscale{
+      Enabled:           true,
+      MinWorkers:        1,
+      MaxWorkers:        10,
+      Interval:          10 * time.Second,
+      TargetDrain:       10 * time.Second,
+      ScaleUpCooldown:   0,
+      ScaleDownCooldown: time.Minute,
+      HysteresisWindow:  30 * time.Second,
+    },
+  }
+  wp, err := NewWorkerPool(config.WrapWorkerPoolContext(context.Background(), cfg), repo)
+  if err != nil {
+    t.Fatal(err)
+  }
+  wp.runCtx = context.Background()
+  if err = wp.Resize(1); err != nil {
+    t.Fatal(err)
+  }
+  t.Cleanup(func() {
+    wp.pullCancel()
+    close(wp.done)
+    wp.wg.Wait()
+  })
+
+  return wp, repo
+}
+
+func TestDesiredWorkers(t *testing.T) {
+  wp := &WorkerPool{cfg: &config.WorkerPool{
+    Autoscale: config.Autoscale{MinWorkers: 2, MaxWorkers: 10, TargetDrain: 10 * time.Second},
+  }}
+
+  tests := []struct {
+    name    string
+    depth   int64
+    latency time.Duration
+    want    int
+  }{
+    {name: "empty queue keeps min", depth: 0, latency: time.Second, want: 2},
+    {name: "exact", depth: 50, latency: time.Second, want: 5},
+    {name: "rounded up", depth: 51, latency: time.Second, want: 6},
+    {name: "slow jobs", depth: 10, latency: 5 * time.Second, want: 5},
+    {name: "capped by max", depth: 1000, latency: time.Second, want: 10},
+  }
+
+  for _, tt := range tests {
+    t.Run(tt.name, func(t *testing.T) {
+      if got := wp.desiredWorkers(tt.depth, tt.latency); got != tt.want {
+        t.Errorf("desiredWorkers = %d, want %d", got, tt.want)
+      }
+    })
+  }
+}
+
+func TestScaleHysteresis(t *testing.T) {
+  wp, repo := newTestAutoscaler(t)
+  wp.observeLatency(time.Second)
+  ctx := context.Background()
+  start := time.Now()
+  at := func(seconds int) time.Time {
+    return start.Add(time.Duration(seconds) * time.Second)
+  }
+  step := func(seconds int, depth int64, want int) {
+    t.Helper()
+    repo.depth = depth
+    wp.scale(ctx, at(seconds))
+    if got := wp.Workers(); got != want {
+      t.Fatalf("at +%ds with depth %d: %d workers, want %d", seconds, depth, got, want)
+    }
+  }
+
+  // рост применяется, только когда замеры указывают вверх всё окно, и до самого осторожного из них
+  step(0, 50, 1)
+  step(10, 80, 1)
+  step(30, 60, 5)
+
+  // окно набрано, но после роста ещё не прошёл cooldown на уменьшение
+  step(40, 0, 5)
+  step(70, 0, 5)
+  step(90, 20, 2)
+
+  // разнонаправленные замеры сбрасывают окно
+  step(100, 0, 2)
+  step(120, 50, 2)
+  step(140, 0, 2)
+  step(160, 0, 2)
+  step(170, 0, 1)
+
+  decisions := wp.Autoscaler().Decisions
+  if len(decisions) != 3 || decisions[0].From != 1 || decisions[0].To != 5 || decisions[1].To != 2 ||
+    decisions[2].To != 1 {
+    t.Errorf("unexpected decisions %+v", decisions)
+  }
+}
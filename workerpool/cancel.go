@@ -0,0 +1,144 @@
+package workerpool
+
+import (
+  "context"
+  "sort"
+  "time"
+
+  errs "flussonic_tz/internal/errors"
+  "flussonic_tz/internal/service"
+  "flussonic_tz/models"
+
+  "github.com/pkg/errors"
+  "github.com/rs/zerolog/log"
+)
+
+var (
+  ErrJobCancelled = errors.New(errs.ErrJobCancelled)
+  ErrPoolStopped  = errors.New(errs.ErrPoolStopped)
+  ErrLeaseLost    = errors.New(errs.ErrLeaseLost)
+
+  // errCancelledByAPI - причина отмены по сообщению из канала отмен, статус джобы в redis к этому моменту уже
+  // cancelled
+  errCancelledByAPI = errors.Wrap(ErrJobCancelled, "by api")
+)
+
+// jobContext создаёт контекст джобы, который отменяется при остановке пула или явной отмене джобы через Cancel
+func (wp *WorkerPool) jobContext(jobID string) (context.Context, func()) {
+  ctx, cancel := context.WithCancelCause(wp.ctx)
+
+  wp.runningMu.Lock()
+  wp.running[jobID] = cancel
+  wp.runningMu.Unlock()
+
+  return ctx, func() {
+    wp.runningMu.Lock()
+    delete(wp.running, jobID)
+    wp.runningMu.Unlock()
+    cancel(nil)
+  }
+}
+
+// Cancel отменяет обработчик джобы, если она выполняется на этом инстансе. статус джобы в redis меняет runJob,
+// когда обработчик вернётся
+func (wp *WorkerPool) Cancel(jobID string) bool {
+  ok := wp.cancelJob(jobID, ErrJobCancelled)
+  if ok {
+    log.Info().Str("job_id", jobID).Msg("job cancelled")
+  }
+
+  return ok
+}
+
+func (wp *WorkerPool) cancelJob(jobID string, cause error) bool {
+  wp.runningMu.Lock()
+  cancel, ok := wp.running[jobID]
+  wp.runningMu.Unlock()
+
+  if ok {
+    cancel(cause)
+  }
+
+  return ok
+}
+
+// cancellations отменяет обработчики джоб, которые отменили через API на любом инстансе. работает, пока не отменён
+// контекст пула, чтобы отмена доходила и до джоб, которые дорабатывают во время остановки
+func (wp *WorkerPool) cancellations() {
+  defer wp.registryWg.Done()
+
+  for {
+    err := wp.repo.WatchCancellations(wp.ctx, func(jobID string) {
+      if wp.cancelJob(jobID, errCancelledByAPI) {
+        log.Info().Str("job_id", jobID).Msg("job cancelled")
+      }
+    })
+    if err == nil {
+      return
+    }
+
+    select {
+    case <-time.After(wp.cfg.IdleBackoff):
+    case <-wp.ctx.Done():
+      return
+    }
+  }
+}
+
+// markCancelled переводит в cancelled джобу, обработчик которой отменили через Cancel. при отмене через API
+// статус уже выставлен
+func (wp *WorkerPool) markCancelled(ctx context.Context, jobID string, cause error) {
+  if errors.Is(cause, errCancelledByAPI) {
+    return
+  }
+
+  _, err := wp.repo.CancelJob(ctx, jobID, errs.ErrJobCancelled)
+  if err != nil && !errors.Is(err, service.ErrIllegalTransition) {
+    wrapped := errors.Wrap(err, errs.ErrCancelJob)
+    log.Error().Err(wrapped).Msg(wrapped.Error())
+  }
+}
+
+// awaitHandler ждёт завершения обработчика после отмены контекста. если обработчик не уложился в grace period,
+// он считается зависшим и остаётся в списке, пока не завершится сам
+func (wp *WorkerPool) awaitHandler(jobID, name string, outcomes <-chan outcome) {
+  select {
+  case <-outcomes:
+    return
+  case <-time.After(wp.cfg.CancelGracePeriod):
+  }
+
+  cancelledAt := time.Now().Add(-wp.cfg.CancelGracePeriod)
+  wp.stuckMu.Lock()
+  wp.stuck[jobID] = models.StuckJob{ID: jobID, Name: name, CancelledAt: cancelledAt}
+  wp.stuckMu.Unlock()
+  log.Warn().Str("job_id", jobID).Str("name", name).Msg(errs.ErrHandlerIgnoresCancel)
+
+  go func() {
+    <-outcomes
+
+    wp.stuckMu.Lock()
+    delete(wp.stuck, jobID)
+    wp.stuckMu.Unlock()
+    log.Warn().
+      Str("job_id", jobID).
+      Str("name", name).
+      Dur("overrun", time.Since(cancelledAt)).
+      Msg("stuck handler finished")
+  }()
+}
+
+func (wp *WorkerPool) Stuck() []models.StuckJob {
+  wp.stuckMu.Lock()
+  defer wp.stuckMu.Unlock()
+
+  jobs := make([]models.StuckJob, 0, len(wp.stuck))
+  for _, job := range wp.stuck {
+    jobs = append(jobs, job)
+  }
+  sort.Slice(jobs, func(i, j int) bool {
+    return jobs[i].CancelledAt.Before(jobs[j].CancelledAt)
+  })
+
+  return jobs
+}
@@ -0,0 +1,83 @@
+package workerpool
+
+import (
+  "context"
+  "time"
+
+  errs "flussonic_tz/internal/errors"
+  "flussonic_tz/models"
+
+  "github.com/pkg/errors"
+  "github.com/rs/zerolog/log"
+)
+
+// Stop перестаёт брать новые джобы и ждёт завершения запущенных не дольше drain timeout. джобы, которые не успели
+// завершиться, отменяются и возвращаются в очередь со статусом interrupted
+func (wp *WorkerPool) Stop() models.DrainSummary {
+  // под workersMu, чтобы Resize не запустил новых воркеров после начала остановки
+  wp.workersMu.Lock()
+  wp.cond.L.Lock()
+  wp.stopping = true
+  wp.cond.Broadcast()
+  wp.cond.L.Unlock()
+  wp.workersMu.Unlock()
+
+  wp.pullCancel()
+  close(wp.done)
+
+  log.Info().Int64("in_flight", wp.active.Load()).Dur("timeout", wp.cfg.DrainTimeout).Msg("draining worker pool")
+
+  // воркеры выполняют джобы сами, поэтому их завершение и означает, что все джобы закончились
+  finished := make(chan struct{})
+  go func() {
+    wp.wg.Wait()
+    close(finished)
+  }()
+
+  select {
+  case <-finished:
+  case <-time.After(wp.cfg.DrainTimeout):
+    log.Warn().Int64("remaining", wp.active.Load()).Msg("drain timeout exceeded, interrupting jobs")
+    wp.cancel(ErrPoolStopped)
+    <-finished
+  }
+  wp.cancel(ErrPoolStopped)
+  wp.registryWg.Wait()
+
+  inFlight := int(wp.stopped.Load())
+  requeued := int(wp.interrupts.Load())
+  failed := int(wp.interruptFailures.Load())
+  return models.DrainSummary{
+    InFlight:        inFlight,
+    Drained:         inFlight - requeued - failed,
+    Requeued:        requeued,
+    InterruptFailed: failed,
+  }
+}
+
+// finish учитывает завершение джобы, джобы, завершившиеся после начала остановки, попадают в итог drain
+func (wp *WorkerPool) finish() {
+  if wp.isStopping() {
+    wp.stopped.Add(1)
+  }
+  wp.active.Add(-1)
+}
+
+func (wp *WorkerPool) isStopping() bool {
+  wp.cond.L.Lock()
+  defer wp.cond.L.Unlock()
+
+  return wp.stopping
+}
+
+func (wp *WorkerPool) interrupt(ctx context.Context, jobID string) {
+  if err := wp.repo.InterruptJob(ctx, jobID); err != nil {
+    wrapped := errors.Wrap(err, errs.ErrInterruptJob)
+    log.Error().Err(wrapped).Msg(wrapped.Error())
+    wp.interruptFailures.Add(1)
+    return
+  }
+
+  wp.interrupts.Add(1)
+  log.Info().Str("job_id", jobID).Msg("job interrupted and requeued")
+}
@@ -0,0 +1,37 @@
+package workerpool
+
+import (
+  "context"
+  "time"
+
+  errs "flussonic_tz/internal/errors"
+
+  "github.com/pkg/errors"
+  "github.com/rs/zerolog/log"
+)
+
+// sweeper завершает джобы, которые не успели взять в работу до expires_at. воркеры и сами пропускают такие джобы,
+// но при простое или длинной очереди до них никто не доходит, и без sweeper они висели бы в очереди
+func (wp *WorkerPool) sweeper(ctx context.Context) {
+  defer wp.wg.Done()
+
+  ticker := time.NewTicker(wp.cfg.SweepInterval)
+  defer ticker.Stop()
+
+  for {
+    select {
+    case <-wp.done:
+      return
+    case <-ticker.C:
+      count, err := wp.repo.ExpireJobs(ctx, time.Now())
+      if err != nil {
+        wrapped := errors.Wrap(err, errs.ErrExpireJobs)
+        log.Error().Err(wrapped).Msg(wrapped.Error())
+        continue
+      }
+      if count > 0 {
+        log.Info().Int("count", count).Msg("expired jobs swept")
+      }
+    }
+  }
+}
@@ -0,0 +1,61 @@
+package workerpool
+
+import (
+  "context"
+  "encoding/json"
+
+  errs "flussonic_tz/internal/errors"
+
+  "github.com/avast/retry-go"
+  "github.com/pkg/errors"
+)
+
+// Handler возвращает результат джобы, он сохраняется при успешном завершении и отдаётся через /jobs/{job_id}/result
+type Handler func(ctx context.Context, name string, jobData []byte) ([]byte, error)
+
+type TypedHandler[T, R any] func(ctx context.Context, name string, payload T) (R, error)
+
+func (wp *WorkerPool) Handle(jobName string, handler Handler) {
+  wp.handlersMu.Lock()
+  wp.handlers[jobName] = handler
+  wp.handlersMu.Unlock()
+}
+
+// Register регистрирует обработчик, который получает уже декодированный из JSON payload, а его результат
+// сохраняется в JSON
+func Register[T, R any](wp *WorkerPool, jobName string, handler TypedHandler[T, R]) {
+  wp.Handle(jobName, func(ctx context.Context, name string, jobData []byte) ([]byte, error) {
+    // джоба без payload получает нулевое значение T
+    var payload T
+    if len(jobData) > 0 {
+      if err := json.Unmarshal(jobData, &payload); err != nil {
+        // повторная попытка декодирования ничего не изменит, поэтому ретраи не нужны
+        return nil, retry.Unrecoverable(errors.Wrap(err, errs.ErrDecodePayload))
+      }
+    }
+
+    result, err := handler(ctx, name, payload)
+    if err != nil {
+      return nil, err
+    }
+
+    data, err := json.Marshal(result)
+    if err != nil {
+      return nil, retry.Unrecoverable(errors.Wrap(err, errs.ErrEncodeResult))
+    }
+
+    return data, nil
+  })
+}
+
+func (wp *WorkerPool) handler(jobName string) (Handler, error) {
+  wp.handlersMu.RLock()
+  defer wp.handlersMu.RUnlock()
+
+  handler, ok := wp.handlers[jobName]
+  if !ok {
+    return nil, retry.Unrecoverable(errors.Errorf("%s: %s", errs.ErrUnknownJob, jobName))
+  }
+
+  return handler, nil
+}
@@ -0,0 +1,45 @@
+package workerpool
+
+import (
+  "context"
+  "testing"
+
+  "github.com/avast/retry-go"
+)
+
+type emailPayload struct {
+  To string `json:"to"`
+}
+
+func TestRegister(t *testing.T) {
+  wp := &WorkerPool{handlers: make(map[string]Handler)}
+  var got emailPayload
+  Register(wp, "send_email", func(_ context.Context, _ string, payload emailPayload) (map[string]string, error) {
+    got = payload
+    return map[string]string{"sent_to": payload.To}, nil
+  })
+
+  handler, err := wp.handler("send_email")
+  if err != nil {
+    t.Fatal(err)
+  }
+
+  result, err := handler(context.Background(), "send_email", []byte(`{"to": "user@example.com"}`))
+  if err != nil || got.To != "user@example.com" || string(result) != `{"sent_to":"user@example.com"}` {
+    t.Errorf("payload %+v, result %s, err %v", got, result, err)
+  }
+
+  // джоба без payload получает нулевое значение
+  got = emailPayload{To: "stale"}
+  if _, err = handler(context.Background(), "send_email", nil); err != nil || got != (emailPayload{}) {
+    t.Errorf("empty payload: payload %+v, err %v", got, err)
+  }
+
+  if _, err = handler(context.Background(), "send_email", []byte(`{broken`)); err == nil || retry.IsRecoverable(err) {
+    t.Errorf("invalid payload must fail without retries, got %v", err)
+  }
+
+  if _, err = wp.handler("unknown"); err == nil || retry.IsRecoverable(err) {
+    t.Errorf("unknown job must fail without retries, got %v", err)
+  }
+}
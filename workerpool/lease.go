@@ -0,0 +1,65 @@
+package workerpool
+
+import (
+  "context"
+  "time"
+
+  errs "flussonic_tz/internal/errors"
+
+  "github.com/pkg/errors"
+  "github.com/rs/zerolog/log"
+)
+
+const (
+  // lease продлевается несколько раз за свой срок, чтобы одна неудачная попытка не привела к его потере
+  HeartbeatsPerLease = 3
+)
+
+// heartbeat продлевает lease джобы, пока она выполняется. если lease потерян, джобу уже вернули в очередь,
+// поэтому текущее выполнение отменяется
+func (wp *WorkerPool) heartbeat(ctx, jobCtx context.Context, jobID string) {
+  ticker := time.NewTicker(wp.cfg.LeaseDuration / HeartbeatsPerLease)
+  defer ticker.Stop()
+
+  for {
+    select {
+    case <-jobCtx.Done():
+      return
+    case <-ticker.C:
+      ok, err := wp.repo.RenewLease(ctx, jobID, wp.cfg.LeaseDuration)
+      if err != nil {
+        continue
+      }
+      if !ok {
+        log.Warn().Str("job_id", jobID).Msg(errs.ErrLeaseLost)
+        wp.cancelJob(jobID, ErrLeaseLost)
+        return
+      }
+    }
+  }
+}
+
+// reaper возвращает в очередь джобы, чей lease истёк, например, после падения инстанса
+func (wp *WorkerPool) reaper(ctx context.Context) {
+  defer wp.wg.Done()
+
+  ticker := time.NewTicker(wp.cfg.ReaperInterval)
+  defer ticker.Stop()
+
+  for {
+    select {
+    case <-wp.done:
+      return
+    case <-ticker.C:
+      requeued, failed, err := wp.repo.RequeueExpired(ctx, time.Now(), wp.cfg.MaxRedeliveries)
+      if err != nil {
+        wrapped := errors.Wrap(err, errs.ErrRequeueExpired)
+        log.Error().Err(wrapped).Msg(wrapped.Error())
+        continue
+      }
+      if requeued > 0 || failed > 0 {
+        log.Info().Int("requeued", requeued).Int("failed", failed).Msg("orphaned jobs reaped")
+      }
+    }
+  }
+}
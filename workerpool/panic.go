@@ -0,0 +1,50 @@
+package workerpool
+
+import (
+  "context"
+  "fmt"
+  "runtime/debug"
+
+  errs "flussonic_tz/internal/errors"
+
+  "github.com/pkg/errors"
+  "github.com/rs/zerolog/log"
+)
+
+// PanicError - паника в обработчике, перехваченная в execute. считается обычной упавшей попыткой и ретраится
+type PanicError struct {
+  Value interface{}
+  Stack string
+}
+
+func (e *PanicError) Error() string {
+  return fmt.Sprintf("%s: %v", errs.ErrJobPanicked, e.Value)
+}
+
+// safeCall вызывает обработчик и превращает панику в PanicError, чтобы она не уронила весь процесс
+func safeCall(ctx context.Context, handler Handler, name string, jobData []byte) (result []byte, err error) {
+  defer func() {
+    if v := recover(); v != nil {
+      result, err = nil, &PanicError{Value: v, Stack: string(debug.Stack())}
+    }
+  }()
+
+  return handler(ctx, name, jobData)
+}
+
+// countFailure учитывает упавшую попытку в статистике, паники считаются отдельно и их стек сохраняется в джобе
+func (wp *WorkerPool) countFailure(ctx context.Context, jobID string, jobErr error) {
+  var panicErr *PanicError
+  if !errors.As(jobErr, &panicErr) {
+    wp.failures.Add(1)
+    return
+  }
+
+  wp.panics.Add(1)
+  log.Error().Str("job_id", jobID).Str("stack", panicErr.Stack).Msg(panicErr.Error())
+
+  if err := wp.repo.RecordPanic(ctx, jobID, fmt.Sprint(panicErr.Value), panicErr.Stack); err != nil {
+    wrapped := errors.Wrap(err, errs.ErrRecordPanic)
+    log.Error().Err(wrapped).Msg(wrapped.Error())
+  }
+}
@@ -0,0 +1,90 @@
+package workerpool
+
+import (
+  "context"
+  "sync"
+  "time"
+
+  errs "flussonic_tz/internal/errors"
+  "flussonic_tz/internal/service"
+  "flussonic_tz/models"
+
+  "github.com/pkg/errors"
+  "github.com/rs/zerolog/log"
+)
+
+type progressKey struct{}
+
+type progressUpdate struct {
+  percent int
+  message string
+  at      time.Time
+}
+
+// Progress сохраняет прогресс джобы в её хэше. запись идёт не чаще progress_interval, промежуточные значения
+// между записями отбрасываются, а последнее записывается после завершения обработчика
+type Progress struct {
+  mu       sync.Mutex
+  ctx      context.Context
+  repo     service.JobRepository
+  jobID    string
+  delivery int
+  interval time.Duration
+  written  time.Time
+  pending  *progressUpdate
+}
+
+// ProgressFromContext возвращает reporter джобы из контекста обработчика. вне обработчика возвращается nil,
+// у которого Report ничего не делает
+func ProgressFromContext(ctx context.Context) *Progress {
+  progress, _ := ctx.Value(progressKey{}).(*Progress)
+  return progress
+}
+
+func (wp *WorkerPool) newProgress(job *models.Job) *Progress {
+  return &Progress{
+    ctx:      wp.runCtx,
+    repo:     wp.repo,
+    jobID:    job.ID,
+    delivery: job.Delivery,
+    interval: wp.cfg.ProgressInterval,
+  }
+}
+
+// Report сообщает прогресс в процентах от 0 до 100 и текстовое описание текущего шага
+func (p *Progress) Report(percent int, message string) {
+  if p == nil {
+    return
+  }
+
+  p.mu.Lock()
+  defer p.mu.Unlock()
+
+  p.pending = &progressUpdate{percent: min(max(percent, 0), 100), message: message, at: time.Now()}
+  // 100% пишем сразу, чтобы завершение шага не потерялось
+  if time.Since(p.written) < p.interval && p.pending.percent < 100 {
+    return
+  }
+  p.write()
+}
+
+func (p *Progress) flush() {
+  p.mu.Lock()
+  defer p.mu.Unlock()
+
+  if p.pending != nil {
+    p.write()
+  }
+}
+
+func (p *Progress) write() {
+  update := p.pending
+  p.pending = nil
+  p.written = update.at
+
+  err := p.repo.SetProgress(p.ctx, p.jobID, p.delivery, update.percent, update.message, update.at)
+  if err != nil {
+    wrapped := errors.Wrap(err, errs.ErrReportProgress)
+    log.Error().Err(wrapped).Msg(wrapped.Error())
+  }
+}
@@ -0,0 +1,65 @@
+package workerpool
+
+import (
+  "context"
+  "time"
+
+  errs "flussonic_tz/internal/errors"
+  "flussonic_tz/models"
+
+  "github.com/pkg/errors"
+  "github.com/rs/zerolog/log"
+)
+
+// registry публикует в redis состояние инстанса и его воркеров, пока пул не остановлен. если инстанс упал и
+// перестал обновлять запись, она пропадает через registry_ttl
+func (wp *WorkerPool) registry(ctx context.Context) {
+  defer wp.registryWg.Done()
+
+  ticker := time.NewTicker(wp.cfg.RegistryInterval)
+  defer ticker.Stop()
+
+  wp.register(ctx)
+  for {
+    select {
+    // ctx пула отменяется, когда все джобы завершены, до этого инстанс должен оставаться в реестре
+    case <-wp.ctx.Done():
+      if err := wp.repo.UnregisterInstance(ctx, wp.instanceID); err != nil {
+        wrapped := errors.Wrap(err, errs.ErrUnregisterInstance)
+        log.Error().Err(wrapped).Msg(wrapped.Error())
+      }
+      return
+    case <-ticker.C:
+      wp.register(ctx)
+    }
+  }
+}
+
+func (wp *WorkerPool) register(ctx context.Context) {
+  if err := wp.repo.RegisterInstance(ctx, wp.instance(), wp.cfg.RegistryTTL); err != nil {
+    wrapped := errors.Wrap(err, errs.ErrRegisterInstance)
+    log.Error().Err(wrapped).Msg(wrapped.Error())
+  }
+}
+
+func (wp *WorkerPool) instance() *models.Instance {
+  wp.workersMu.Lock()
+  workers := make([]models.WorkerState, 0, len(wp.workers))
+  for _, w := range wp.workers {
+    workers = append(workers, w.state())
+  }
+  wp.workersMu.Unlock()
+
+  return &models.Instance{
+    ID:          wp.instanceID,
+    Host:        wp.host,
+    StartedAt:   wp.startedAt,
+    HeartbeatAt: time.Now(),
+    Workers:     workers,
+  }
+}
+
+// Instances возвращает все живые инстансы, работающие с этой очередью, включая текущий
+func (wp *WorkerPool) Instances(ctx context.Context) ([]models.Instance, error) {
+  return wp.repo.ListInstances(ctx, time.Now())
+}
@@ -0,0 +1,85 @@
+package workerpool
+
+import (
+  "context"
+  "sync"
+  "time"
+
+  errs "flussonic_tz/internal/errors"
+  "flussonic_tz/models"
+
+  "github.com/pkg/errors"
+  "github.com/rs/zerolog/log"
+)
+
+type worker struct {
+  id     int
+  quit   context.Context
+  retire context.CancelFunc
+
+  mu           sync.Mutex
+  job          *models.Job
+  jobStartedAt time.Time
+}
+
+func (w *worker) setJob(job *models.Job) {
+  w.mu.Lock()
+  w.job = job
+  w.jobStartedAt = time.Now()
+  w.mu.Unlock()
+}
+
+func (w *worker) state() models.WorkerState {
+  w.mu.Lock()
+  defer w.mu.Unlock()
+
+  state := models.WorkerState{ID: w.id}
+  if w.job != nil {
+    startedAt := w.jobStartedAt
+    state.JobID, state.JobName, state.JobStartedAt = w.job.ID, w.job.Name, &startedAt
+  }
+
+  return state
+}
+
+func (wp *WorkerPool) Workers() int {
+  wp.workersMu.Lock()
+  defer wp.workersMu.Unlock()
+
+  return len(wp.workers)
+}
+
+// Resize меняет количество воркеров на лету. лишние воркеры перестают брать новые джобы, но текущую доделывают
+func (wp *WorkerPool) Resize(n int) error {
+  if n < 1 || n > wp.cfg.MaxWorkers {
+    return errors.Errorf("%s: %d not in [1, %d]", errs.ErrInvalidWorkers, n, wp.cfg.MaxWorkers)
+  }
+
+  wp.workersMu.Lock()
+  defer wp.workersMu.Unlock()
+
+  if wp.isStopping() {
+    return ErrPoolStopped
+  }
+
+  current := len(wp.workers)
+  for len(wp.workers) < n {
+    wp.workerSeq++
+    quit, retire := context.WithCancel(wp.pullCtx)
+    w := &worker{id: wp.workerSeq, quit: quit, retire: retire}
+    wp.workers = append(wp.workers, w)
+
+    wp.wg.Add(1)
+    go wp.worker(wp.runCtx, w)
+  }
+  for len(wp.workers) > n {
+    last := len(wp.workers) - 1
+    wp.workers[last].retire()
+    wp.workers = wp.workers[:last]
+  }
+
+  if current != n {
+    log.Info().Int("from", current).Int("to", n).Msg("worker pool resized")
+  }
+  return nil
+}
@@ -0,0 +1,115 @@
+package workerpool
+
+import (
+  "context"
+  "math"
+  "math/rand"
+  "strings"
+  "time"
+
+  errs "flussonic_tz/internal/errors"
+  "flussonic_tz/models"
+
+  "github.com/avast/retry-go"
+  "github.com/pkg/errors"
+  "github.com/rs/zerolog/log"
+)
+
+// retryPolicy собирает политику джобы: заданная в запросе важнее политики типа джобы, а та важнее глобальной
+func (wp *WorkerPool) retryPolicy(job *models.Job) models.RetryPolicy {
+  policy := wp.cfg.Retry
+  if policy.MaxAttempts == 0 {
+    policy.MaxAttempts = wp.cfg.MaxRetries
+  }
+
+  // viper приводит ключи конфига к нижнему регистру, поэтому тип джобы ищется без учёта регистра
+  typePolicy, ok := wp.cfg.RetryPolicies[job.Name]
+  if !ok {
+    typePolicy, ok = wp.cfg.RetryPolicies[strings.ToLower(job.Name)]
+  }
+  if ok {
+    policy = typePolicy.Merge(policy)
+  }
+
+  if job.Retry != nil {
+    policy = job.Retry.Merge(policy)
+  }
+
+  return policy
+}
+
+// backoff возвращает задержку перед следующей попыткой, attempt - номер упавшей попытки начиная с 1
+func backoff(policy models.RetryPolicy, attempt int) time.Duration {
+  var delay time.Duration
+  if len(policy.Schedule) > 0 {
+    delay = time.Duration(policy.Schedule[max(min(attempt, len(policy.Schedule))-1, 0)])
+  } else {
+    multiplier := max(policy.Multiplier, 1)
+    delay = time.Duration(float64(policy.InitialDelay) * math.Pow(multiplier, float64(max(attempt, 1)-1)))
+  }
+
+  if maxDelay := time.Duration(policy.MaxDelay); maxDelay > 0 && (delay > maxDelay || delay < 0) {
+    delay = maxDelay
+  }
+
+  if policy.Jitter > 0 {
+    // случайное отклонение в пределах ±jitter от задержки, чтобы ретраи разных джоб не совпадали по времени
+    delay += time.Duration((rand.Float64()*2 - 1) * policy.Jitter * float64(delay))
+  }
+
+  return max(delay, 0)
+}
+
+// retryOrFail возвращает упавшую джобу в очередь отложенных, чтобы ожидание ретрая не занимало воркер. если попытки
+// закончились или ошибка неретраебальная, джоба падает окончательно
+func (wp *WorkerPool) retryOrFail(ctx context.Context, job *models.Job, jobErr error) {
+  policy := wp.retryPolicy(job)
+  record := models.AttemptRecord{
+    Attempt:   job.Attempt,
+    Error:     jobErr.Error(),
+    StartedAt: job.StartedAt,
+    FailedAt:  time.Now(),
+  }
+
+  if !retry.IsRecoverable(jobErr) || job.Attempt >= policy.MaxAttempts {
+    if err := wp.repo.FailJob(ctx, job.ID, record); err != nil {
+      wrapped := errors.Wrap(err, errs.ErrFailJob)
+      log.Error().Err(wrapped).Msg(wrapped.Error())
+    }
+    return
+  }
+
+  runAt := record.FailedAt.Add(backoff(policy, job.Attempt))
+  if err := wp.repo.RetryJob(ctx, job, runAt, record); err != nil {
+    wrapped := errors.Wrap(err, errs.ErrRetryJob)
+    log.Error().Err(wrapped).Msg(wrapped.Error())
+    return
+  }
+
+  log.Info().Str("job_id", job.ID).Int("attempt", job.Attempt).Time("next_retry_at", runAt).Msg("job scheduled for retry")
+}
+
+// promoter переносит ретраи и запланированные джобы, время которых наступило, в очередь
+func (wp *WorkerPool) promoter(ctx context.Context) {
+  defer wp.wg.Done()
+
+  ticker := time.NewTicker(wp.cfg.PromoteInterval)
+  defer ticker.Stop()
+
+  for {
+    select {
+    case <-wp.done:
+      return
+    case <-ticker.C:
+      count, err := wp.repo.PromoteJobs(ctx, time.Now())
+      if err != nil {
+        wrapped := errors.Wrap(err, errs.ErrPromoteJobs)
+        log.Error().Err(wrapped).Msg(wrapped.Error())
+        continue
+      }
+      if count > 0 {
+        log.Info().Int("count", count).Msg("delayed jobs promoted")
+      }
+    }
+  }
+}
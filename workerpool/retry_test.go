@@ -0,0 +1,80 @@
+package workerpool
+
+import (
+  "testing"
+  "time"
+
+  "flussonic_tz/config"
+  "flussonic_tz/models"
+)
+
+func TestBackoff(t *testing.T) {
+  exponential := models.RetryPolicy{
+    InitialDelay: models.Duration(time.Second), Multiplier: 2, MaxDelay: models.Duration(10 * time.Second),
+  }
+  schedule := models.RetryPolicy{Schedule: []models.Duration{
+    models.Duration(time.Second), models.Duration(5 * time.Second), models.Duration(30 * time.Second),
+  }}
+
+  tests := []struct {
+    name    string
+    policy  models.RetryPolicy
+    attempt int
+    want    time.Duration
+  }{
+    {name: "first attempt", policy: exponential, attempt: 1, want: time.Second},
+    {name: "grows by multiplier", policy: exponential, attempt: 3, want: 4 * time.Second},
+    {name: "capped by max_delay", policy: exponential, attempt: 5, want: 10 * time.Second},
+    {name: "overflow capped by max_delay", policy: exponential, attempt: 1000, want: 10 * time.Second},
+    {name: "zero attempt", policy: exponential, attempt: 0, want: time.Second},
+    {name: "multiplier below one", attempt: 3, want: time.Second,
+      policy: models.RetryPolicy{InitialDelay: models.Duration(time.Second), Multiplier: 0.5}},
+    {name: "schedule", policy: schedule, attempt: 2, want: 5 * time.Second},
+    {name: "schedule repeats last delay", policy: schedule, attempt: 7, want: 30 * time.Second},
+    {name: "schedule zero attempt", policy: schedule, attempt: 0, want: time.Second},
+    {name: "schedule negative attempt", policy: schedule, attempt: -1, want: time.Second},
+  }
+
+  for _, tt := range tests {
+    t.Run(tt.name, func(t *testing.T) {
+      if got := backoff(tt.policy, tt.attempt); got != tt.want {
+        t.Errorf("backoff = %s, want %s", got, tt.want)
+      }
+    })
+  }
+}
+
+func TestBackoffJitter(t *testing.T) {
+  policy := models.RetryPolicy{InitialDelay: models.Duration(10 * time.Second), Jitter: 0.2}
+  for range 1000 {
+    if delay := backoff(policy, 1); delay < 8*time.Second || delay > 12*time.Second {
+      t.Fatalf("delay %s out of ±20%% range", delay)
+    }
+  }
+}
+
+func TestRetryPolicy(t *testing.T) {
+  wp := &WorkerPool{cfg: &config.WorkerPool{
+    MaxRetries: 3,
+    Retry:      models.RetryPolicy{InitialDelay: models.Duration(time.Second), Multiplier: 2, Jitter: 0.2},
+    // так ключи приходят из viper
+    RetryPolicies: map[string]models.RetryPolicy{
+      "sendemail": {MaxAttempts: 5, Schedule: []models.Duration{models.Duration(time.Minute)}},
+    },
+  }}
+
+  policy := wp.retryPolicy(&models.Job{Name: "other"})
+  if policy.MaxAttempts != 3 || policy.InitialDelay != models.Duration(time.Second) {
+    t.Errorf("global policy: %+v", policy)
+  }
+
+  policy = wp.retryPolicy(&models.Job{Name: "SendEmail"})
+  if policy.MaxAttempts != 5 || len(policy.Schedule) != 1 || policy.Multiplier != 2 || policy.Jitter != 0.2 {
+    t.Errorf("type policy isn't merged with the global one: %+v", policy)
+  }
+
+  policy = wp.retryPolicy(&models.Job{Name: "SendEmail", Retry: &models.RetryPolicy{MaxAttempts: 1, Jitter: 0.5}})
+  if policy.MaxAttempts != 1 || policy.Jitter != 0.5 || len(policy.Schedule) != 1 || policy.Multiplier != 2 {
+    t.Errorf("job policy isn't merged with the type one: %+v", policy)
+  }
+}
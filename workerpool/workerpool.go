@@ -2,8 +2,10 @@ package workerpool
 
 import (
   "context"
+  "encoding/json"
   "fmt"
   "math/rand"
+  "os"
   "sync"
   "sync/atomic"
   "time"
@@ -15,37 +17,109 @@ import (
 
   "flussonic_tz/config"
   "flussonic_tz/internal/service"
+  "flussonic_tz/models"
+  "flussonic_tz/pkg/generator"
+  "flussonic_tz/pkg/ratelimit"
 
   "github.com/avast/retry-go"
 )
 
-var count uint64
+// outcome - то, чем завершился обработчик
+type outcome struct {
+  result []byte
+  err    error
+}
 
 type WorkerPool struct {
-  cfg       *config.WorkerPool
-  repo      service.JobRepository
-  wg        *sync.WaitGroup
-  semaphore chan struct{}
-  done      chan struct{}
-  doneJob   chan struct{}
-  ticker    *time.Ticker
-  pause     bool
-  cond      *sync.Cond
+  cfg     *config.WorkerPool
+  repo    service.JobRepository
+  limiter ratelimit.Limiter
+  wg      *sync.WaitGroup
+  done    chan struct{}
+  pause   bool
+  cond    *sync.Cond
+
+  // ctx отменяется только после истечения drain timeout, pullCtx - сразу при остановке, чтобы перестать брать джобы
+  ctx        context.Context
+  cancel     context.CancelCauseFunc
+  pullCtx    context.Context
+  pullCancel context.CancelFunc
+  stopping   bool
+  active     atomic.Int64
+  stopped    atomic.Int64
+  interrupts atomic.Int64
+  // джобы, которые не удалось вернуть в очередь при остановке, их вернёт reaper после истечения lease
+  interruptFailures atomic.Int64
+  failures          atomic.Int64
+  panics            atomic.Int64
+
+  // runCtx - контекст из Start, в нём работают воркеры, в том числе добавленные через Resize
+  runCtx    context.Context
+  workersMu sync.Mutex
+  workers   []*worker
+  workerSeq int
+  scaler    autoscaler
+
+  instanceID string
+  host       string
+  startedAt  time.Time
+  // registryWg ждёт горутины, которые должны работать, пока не отменён ctx пула
+  registryWg sync.WaitGroup
+
+  handlersMu sync.RWMutex
+  handlers   map[string]Handler
+
+  runningMu sync.Mutex
+  running   map[string]context.CancelCauseFunc
+  stuckMu   sync.Mutex
+  stuck     map[string]models.StuckJob
 }
 
-func NewWorkerPool(ctx context.Context, repo service.JobRepository) *WorkerPool {
+func NewWorkerPool(ctx context.Context, repo service.JobRepository) (*WorkerPool, error) {
   cfg := config.FromWorkerPoolContext(ctx)
-  return &WorkerPool{
-    cfg:       cfg,
-    repo:      repo,
-    done:      make(chan struct{}),
-    semaphore: make(chan struct{}, cfg.JobLimit),
-    doneJob:   make(chan struct{}, cfg.JobLimit),
-    wg:        &sync.WaitGroup{},
-    ticker:    time.NewTicker(cfg.JobInterval),
-    pause:     false,
-    cond:      sync.NewCond(&sync.Mutex{}),
+  if err := validateAutoscale(cfg); err != nil {
+    log.Error().Err(err).Msg(err.Error())
+    return nil, err
+  }
+
+  limiter, err := ratelimit.New(cfg.RateAlgorithm, cfg.JobLimit, cfg.JobInterval, cfg.RateBurst)
+  if err != nil {
+    wrapped := errors.Wrap(err, errs.ErrCreateRateLimiter)
+    log.Error().Err(wrapped).Msg(wrapped.Error())
+    return nil, wrapped
+  }
+
+  instanceID, err := generator.GenerateID(8)
+  if err != nil {
+    return nil, err
+  }
+  host, err := os.Hostname()
+  if err != nil {
+    wrapped := errors.Wrap(err, errs.ErrGetHostname)
+    log.Error().Err(wrapped).Msg(wrapped.Error())
+    return nil, wrapped
   }
+
+  poolCtx, cancel := context.WithCancelCause(context.Background())
+  pullCtx, pullCancel := context.WithCancel(poolCtx)
+  return &WorkerPool{
+    ctx:        poolCtx,
+    cancel:     cancel,
+    pullCtx:    pullCtx,
+    pullCancel: pullCancel,
+    cfg:        cfg,
+    repo:       repo,
+    limiter:    limiter,
+    done:       make(chan struct{}),
+    wg:         &sync.WaitGroup{},
+    pause:      false,
+    cond:       sync.NewCond(&sync.Mutex{}),
+    handlers:   make(map[string]Handler),
+    running:    make(map[string]context.CancelCauseFunc),
+    stuck:      make(map[string]models.StuckJob),
+    instanceID: instanceID,
+    host:       host,
+  }, nil
 }
 
 func (wp *WorkerPool) Pause() {
@@ -63,143 +137,214 @@ func (wp *WorkerPool) Unpause() {
   wp.cond.L.Unlock()
 }
 
-func (wp *WorkerPool) StartTicker() {
-  defer wp.wg.Done()
+func (wp *WorkerPool) Stats() models.PoolStats {
+  wp.cond.L.Lock()
+  paused := wp.pause
+  wp.cond.L.Unlock()
 
-  for {
-    select {
-    case <-wp.ticker.C:
-      log.Info().Msg("Starting ticker...")
-      // очищаем из семафора только те джобы, которые завершились, поэтому есть отдельный канал doneJob, в который
-      // пишется после окончания функции
-      length := len(wp.doneJob)
-      atomic.StoreUint64(&count, 0)
-      for length > 0 {
-        <-wp.semaphore
-        <-wp.doneJob
-        length--
-      }
-    case <-wp.done:
-      wp.ticker.Stop()
-      return
-    }
+  return models.PoolStats{
+    Workers:  wp.Workers(),
+    Paused:   paused,
+    Failures: wp.failures.Load(),
+    Panics:   wp.panics.Load(),
+    RateLimit: models.RateLimitStats{
+      Algorithm: wp.cfg.RateAlgorithm,
+      Limit:     wp.cfg.JobLimit,
+      Interval:  wp.cfg.JobInterval.String(),
+      Remaining: wp.limiter.Remaining(),
+    },
   }
 }
 
 func (wp *WorkerPool) Start(ctx context.Context) {
-  wp.wg.Add(1)
-  go wp.StartTicker()
-
-  for range wp.cfg.Workers {
+  wp.runCtx = ctx
+  wp.startedAt = time.Now()
+  wp.wg.Add(3)
+  go wp.promoter(ctx)
+  go wp.reaper(ctx)
+  go wp.sweeper(ctx)
+  if wp.cfg.Autoscale.Enabled {
     wp.wg.Add(1)
-    go wp.worker(ctx)
+    go wp.autoscale(ctx)
   }
+
+  if err := wp.Resize(wp.cfg.Workers); err != nil {
+    log.Error().Err(err).Msg(err.Error())
+  }
+
+  wp.registryWg.Add(2)
+  go wp.registry(ctx)
+  go wp.cancellations()
 }
 
 func (wp *WorkerPool) wait() {
   wp.cond.L.Lock()
-  for wp.pause {
+  // при остановке пауза больше не держит джобы, их нужно вернуть в очередь
+  for wp.pause && !wp.stopping {
     wp.cond.Wait()
   }
   wp.cond.L.Unlock()
 }
 
-func (wp *WorkerPool) worker(ctx context.Context) {
+func (wp *WorkerPool) worker(ctx context.Context, w *worker) {
   defer wp.wg.Done()
 
   for {
     select {
     case <-wp.done:
       return
+    case <-w.quit.Done():
+      log.Info().Int("worker", w.id).Msg("worker retired")
+      return
     default:
       // если paused, то будем ждать
       wp.wait()
-      job, err := wp.repo.GetJob(ctx)
+      // слот лимитера занимаем до того, как забрать джобу, иначе джоба будет ждать лимита вне очереди и
+      // более приоритетные джобы не смогут её обогнать
+      if err := wp.limiter.Wait(w.quit); err != nil {
+        continue
+      }
+      job, err := wp.repo.GetJob(ctx, wp.cfg.LeaseDuration)
       if err != nil {
+        wp.limiter.Release()
+        wp.idle(w.quit, err)
         continue
       }
-      // для первого выполнения функции сразу пишем в семафор, чтобы не случилось ситуации, когда воркеры забрали
-      // все задачи из очереди и запустили на каждую из них по горутине, при этом ни одна из горутин не успела
-      // выполниться, а значит семафор пустой. эта запись защитит от этого и заблокируется, когда переполнится канал
-      wp.semaphore <- struct{}{}
-      go func() {
-        retriesCount := 0
-        err = retry.Do(
-          func() error {
-            // если ретрай, то тоже добавляем в семафор, иначе превысим лимит запросов(помимо основных вызовов будут
-            // выполняться ретраи)
-            if retriesCount != 0 {
-              wp.semaphore <- struct{}{}
-            }
-            // пока ждали очередь на задачу могли поставить на паузу, поэтому если paused, то ждём
-            wp.wait()
-            ctxTime, cancel := context.WithTimeout(ctx, wp.cfg.Timeout)
-            defer cancel()
-
-            errChan := make(chan error, 1)
-            go func() {
-              errChan <- wp.PerformJob(fmt.Sprintf("%s:%s", job.Name, job.ID), []byte(job.Name))
-            }()
-
-            select {
-            case <-ctxTime.Done():
-              log.Info().Msg("timeout")
-              return ctxTime.Err()
-            case err = <-errChan:
-              return err
-            }
-          },
-          retry.Attempts(uint(wp.cfg.MaxRetries)),
-          retry.Delay(1*time.Millisecond),
-          retry.OnRetry(func(_ uint, _ error) {
-            retriesCount++
-            // так как функция выполнилась, то пишем в соответствующий канал
-            wp.doneJob <- struct{}{}
-          }),
-        )
-        // если функция в итоге выполнилась успешно, то последнее успешное выполнение надо записать в канал
-        if err == nil {
-          err = wp.repo.CompleteJob(ctx, job.ID)
-          if err != nil {
-            wrapped := errors.Wrap(err, errs.ErrCompleteJob)
-            log.Info().Err(wrapped).Msg(wrapped.Error())
-            return
-          }
-
-          wp.doneJob <- struct{}{}
-        } else {
-          // если все MaxRetries раз упала, то в OnRetry уже были записаны все выполнения
-          err = wp.repo.FailJob(ctx, job.ID)
-          if err != nil {
-            wrapped := errors.Wrap(err, errs.ErrFailJob)
-            log.Error().Err(wrapped).Msg(wrapped.Error())
-            return
-          }
-        }
-      }()
+      w.setJob(job)
+      wp.runJob(ctx, job)
+      w.setJob(nil)
     }
   }
 }
 
-func (wp *WorkerPool) Stop() {
-  close(wp.done)
-  close(wp.semaphore)
-  close(wp.doneJob)
-  wp.wg.Wait()
+func (wp *WorkerPool) runJob(ctx context.Context, job *models.Job) {
+  wp.active.Add(1)
+  defer wp.finish()
+
+  jobCtx, release := wp.jobContext(job.ID)
+  defer release()
+  go wp.heartbeat(ctx, jobCtx, job.ID)
+
+  // пока забирали джобу могли поставить на паузу, поэтому если paused, то ждём
+  wp.wait()
+  if wp.isStopping() {
+    wp.interrupt(ctx, job.ID)
+    return
+  }
+
+  start := time.Now()
+  result, err := wp.execute(jobCtx, job)
+  wp.observeLatency(time.Since(start))
+  // lease потерян, значит джоба уже снова в очереди и её статусом управляет другой воркер
+  if errors.Is(context.Cause(jobCtx), ErrLeaseLost) {
+    return
+  }
+  // пул остановлен до завершения джобы, возвращаем её в очередь
+  if errors.Is(context.Cause(jobCtx), ErrPoolStopped) {
+    wp.interrupt(ctx, job.ID)
+    return
+  }
+  // джобу отменили, ретраить её не нужно
+  if errors.Is(context.Cause(jobCtx), ErrJobCancelled) {
+    wp.markCancelled(ctx, job.ID, context.Cause(jobCtx))
+    return
+  }
+  if err != nil {
+    wp.countFailure(ctx, job.ID, err)
+    wp.retryOrFail(ctx, job, err)
+    return
+  }
+
+  err = wp.repo.CompleteJob(ctx, job.ID, result)
+  // результат не поместился, повторный запуск это не исправит
+  if errors.Is(err, service.ErrResultTooLarge) {
+    wp.countFailure(ctx, job.ID, err)
+    wp.retryOrFail(ctx, job, retry.Unrecoverable(err))
+    return
+  }
+  if err != nil {
+    wrapped := errors.Wrap(err, errs.ErrCompleteJob)
+    log.Info().Err(wrapped).Msg(wrapped.Error())
+  }
 }
 
-func (wp *WorkerPool) PerformJob(name string, jobData []byte) error {
-  atomic.AddUint64(&count, 1)
-  fmt.Printf("Started job %s at %d task: %d\n", name, time.Now().UnixMilli(), atomic.LoadUint64(&count))
+// idle ждёт появления джоб, если очередь пуста, или делает паузу после ошибки, чтобы не долбить redis в цикле.
+// quit отменяется при остановке пула и при удалении воркера, поэтому ожидание не задерживает Stop и Resize
+func (wp *WorkerPool) idle(quit context.Context, err error) {
+  if errors.Is(err, service.ErrQueueEmpty) {
+    if _, err = wp.repo.WaitForJob(quit, wp.cfg.IdleBackoff); err == nil || quit.Err() != nil {
+      return
+    }
+  }
+
+  select {
+  case <-time.After(wp.cfg.IdleBackoff):
+  case <-quit.Done():
+  case <-wp.done:
+  }
+}
+
+// execute запускает обработчик с контекстом, который отменяется по таймауту, остановке пула или отмене джобы
+func (wp *WorkerPool) execute(jobCtx context.Context, job *models.Job) ([]byte, error) {
+  handler, err := wp.handler(job.Name)
+  if err != nil {
+    log.Error().Err(err).Str("job_id", job.ID).Msg(err.Error())
+    return nil, err
+  }
+
+  progress := wp.newProgress(job)
+  defer progress.flush()
+
+  ctxTime, cancel := context.WithTimeout(context.WithValue(jobCtx, progressKey{}, progress), wp.cfg.Timeout)
+  defer cancel()
+
+  outcomes := make(chan outcome, 1)
+  go func() {
+    result, err := safeCall(ctxTime, handler, fmt.Sprintf("%s:%s", job.Name, job.ID), job.Payload)
+    outcomes <- outcome{result: result, err: err}
+  }()
+
+  select {
+  case out := <-outcomes:
+    return out.result, out.err
+  case <-ctxTime.Done():
+  }
+
+  wp.awaitHandler(job.ID, job.Name, outcomes)
+
+  // таймаут ретраим, а отмену джобы или остановку пула - нет
+  cause := context.Cause(jobCtx)
+  if cause == nil {
+    log.Info().Str("job_id", job.ID).Msg("timeout")
+    return nil, ctxTime.Err()
+  }
+
+  log.Info().Err(cause).Str("job_id", job.ID).Msg(cause.Error())
+  return nil, retry.Unrecoverable(cause)
+}
+
+func (wp *WorkerPool) PerformJob(ctx context.Context, name string, jobData []byte) ([]byte, error) {
+  fmt.Printf("Started job %s at %d remaining: %d\n", name, time.Now().UnixMilli(), wp.limiter.Remaining())
 
   // добавил случайную возможность вернуть ошибку чтобы работали ретраи
   if rand.Float64() < wp.cfg.ErrorProbability {
-    return errors.New("Error happend")
+    return nil, errors.New("Error happend")
   }
 
   // do some work. Random sleep time; max = 3s
-  <-time.After(time.Duration(rand.Int63n(int64(3 * time.Second))))
+  workTime := time.Duration(rand.Int63n(int64(3 * time.Second)))
+  progress := ProgressFromContext(ctx)
+  const steps = 10
+  for step := 1; step <= steps; step++ {
+    select {
+    case <-time.After(workTime / steps):
+    case <-ctx.Done():
+      fmt.Printf("Cancelled job %s at %d\n", name, time.Now().UnixMilli())
+      return nil, ctx.Err()
+    }
+    progress.Report(step*100/steps, fmt.Sprintf("step %d of %d", step, steps))
+  }
 
   fmt.Printf("Finished job %s at %d\n", name, time.Now().UnixMilli())
-  return nil
+  return json.Marshal(map[string]string{"work_time": workTime.String()})
 }